@@ -32,6 +32,10 @@ import (
 
 const Poloniex_API = "https://poloniex.com"
 
+// poloniexTakeSlippage is how far beyond the best rate, as a fraction of it,
+// Take lets its orders trade.
+const poloniexTakeSlippage = 0.01
+
 type Poloniex struct {
 	key    string
 	secret []byte
@@ -196,6 +200,80 @@ func (polo *Poloniex) PlaceOrder(buy bool, currencyPair string, amount float64,
 	return res.OrderNumber, nil
 }
 
+type PoloniexTrade struct {
+	Amount string
+	Rate   string
+}
+
+type PoloniexTakeResp struct {
+	OrderNumber     string
+	ResultingTrades []PoloniexTrade
+	Error           string
+}
+
+// Take trades with an immediate-or-cancel order priced poloniexTakeSlippage
+// beyond the best rate on the other side of the market.
+func (polo *Poloniex) Take(buy bool, currencyPair string, amount float64) (float64, float64, error) {
+	ticker, err := polo.GetTicker(currencyPair)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rate := ticker.Bid * (1 - poloniexTakeSlippage)
+	if buy {
+		rate = ticker.Ask * (1 + poloniexTakeSlippage)
+	}
+	if rate <= 0 {
+		return 0, 0, errors.New("NO_LIQUIDITY")
+	}
+
+	apiURL := Poloniex_API + "/tradingApi"
+
+	data := url.Values{}
+	data.Add("nonce", fmt.Sprintf("%d", time.Now().UnixNano()))
+	data.Add("currencyPair", currencyPair)
+	data.Add("amount", fmt.Sprintf("%.8f", amount))
+	data.Add("rate", fmt.Sprintf("%.8f", rate))
+	data.Add("immediateOrCancel", "1")
+	if buy {
+		data.Add("command", "buy")
+	} else {
+		data.Add("command", "sell")
+	}
+
+	m_, err := polo.sendPostRecv(apiURL, data.Encode())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var res PoloniexTakeResp
+	mapstructure.Decode(m_, &res)
+	if res.Error != "" {
+		return 0, 0, errors.New(res.Error)
+	}
+
+	var traded, total float64
+	for _, t := range res.ResultingTrades {
+		a, err := strconv.ParseFloat(t.Amount, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		r, err := strconv.ParseFloat(t.Rate, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		traded += a
+		total += a * r
+	}
+
+	log.Printf("took %f of %s in order %s", traded, currencyPair, res.OrderNumber)
+
+	if traded == 0 {
+		return 0, 0, nil
+	}
+	return traded, total / traded, nil
+}
+
 func (polo *Poloniex) sendGetRecv(url string) (interface{}, error) {
 	req, _ := http.NewRequest("GET", url, nil)
 	return polo.processRequest(req)
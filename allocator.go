@@ -0,0 +1,186 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+)
+
+// Allocator shares the balances of an exchange between the books trading
+// from them. Each book has a budget of every asset it trades that covers its
+// resting orders and the orders it places, reserved before they are placed
+// so that books ticking at the same time cannot spend the same funds.
+type Allocator struct {
+	ex      Exchange
+	weights map[*Book]float64
+	budgets map[string]map[*Book]float64
+
+	// used is what each book last reported holding in resting orders, want
+	// what it last wanted to place and held what it has reserved and not yet
+	// released.
+	used map[string]map[*Book]float64
+	want map[string]map[*Book]float64
+	held map[string]map[*Book]float64
+
+	mu sync.Mutex
+}
+
+func NewAllocator(exchange Exchange) *Allocator {
+	return &Allocator{
+		ex:      exchange,
+		weights: map[*Book]float64{},
+		budgets: map[string]map[*Book]float64{},
+		used:    map[string]map[*Book]float64{},
+		want:    map[string]map[*Book]float64{},
+		held:    map[string]map[*Book]float64{},
+	}
+}
+
+// Add shares the balances with b. Budgets are split in proportion to the
+// weights of the books, 1 if weight is zero.
+func (a *Allocator) Add(b *Book, weight float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if weight == 0 {
+		weight = 1
+	}
+
+	a.weights[b] = weight
+	b.alloc = a
+
+	// Split the budgets again to include the new book
+	a.budgets = map[string]map[*Book]float64{}
+}
+
+// Reserve reserves need of asset for b to place orders with, on top of the
+// used it holds in resting orders, until b calls Release. If b's budget is
+// too small the budgets of every book trading the asset are rebalanced
+// first.
+func (a *Allocator) Reserve(b *Book, asset string, used float64, need float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	free, err := a.ex.GetBalance(asset)
+	if err != nil {
+		return err
+	}
+
+	assign(a.used, asset, b, used)
+	assign(a.want, asset, b, need)
+
+	total := free
+	for _, u := range a.used[asset] {
+		total += u
+	}
+	for o, h := range a.held[asset] {
+		if o != b {
+			free -= h
+		}
+	}
+
+	if a.budgets[asset] == nil || used+need > a.budgets[asset][b] {
+		a.rebalance(asset, total)
+	}
+
+	if budget := a.budgets[asset][b]; used+need > budget {
+		return fmt.Errorf("%s: budget of %s is %f, wanted %f", b.Market, asset, budget, used+need)
+	}
+	if need > free {
+		return fmt.Errorf("%s: not enough %s to place orders, wanted %f, have %f", b.Market, asset, need, free)
+	}
+
+	assign(a.held, asset, b, need)
+
+	return nil
+}
+
+// Release frees what b has reserved once its orders are placed.
+func (a *Allocator) Release(b *Book) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, held := range a.held {
+		delete(held, b)
+	}
+}
+
+// rebalance splits total between the books trading asset in proportion to
+// their weights, then gives the part of a book's share beyond what it last
+// used and wanted to the books that wanted more than their share. The caller
+// must hold a.mu.
+func (a *Allocator) rebalance(asset string, total float64) {
+	var weights float64
+	for b, w := range a.weights {
+		if trades(b, asset) {
+			weights += w
+		}
+	}
+
+	share := map[*Book]float64{}
+	demand := map[*Book]float64{}
+	var surplus, short float64
+	for b, w := range a.weights {
+		if !trades(b, asset) {
+			continue
+		}
+
+		share[b] = total * w / weights
+
+		// A book that has not ticked yet keeps its share
+		demand[b] = share[b]
+		if u, ok := a.used[asset][b]; ok {
+			demand[b] = u + a.want[asset][b]
+		}
+
+		if demand[b] < share[b] {
+			surplus += share[b] - demand[b]
+		} else {
+			short += w
+		}
+	}
+
+	budgets := map[*Book]float64{}
+	for b, s := range share {
+		budgets[b] = math.Min(s, demand[b])
+		if demand[b] >= s {
+			budgets[b] = s + surplus*a.weights[b]/short
+		}
+
+		if old := a.budgets[asset][b]; math.Abs(budgets[b]-old) > 0.1*old {
+			log.Printf("%s: budget of %s changed from %f to %f", b.Market, asset, old, budgets[b])
+		}
+	}
+
+	a.budgets[asset] = budgets
+}
+
+// trades reports whether b trades asset on either side of its market.
+func trades(b *Book, asset string) bool {
+	a, c := splitMarket(b.Market)
+	return asset == a || asset == c
+}
+
+func assign(m map[string]map[*Book]float64, asset string, b *Book, v float64) {
+	if m[asset] == nil {
+		m[asset] = map[*Book]float64{}
+	}
+	m[asset][b] = v
+}
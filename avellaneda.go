@@ -0,0 +1,229 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+)
+
+// Avellaneda quotes either side of a reservation price following Avellaneda
+// and Stoikov, "High-frequency trading in a limit order book" (2008). With
+// the price s, the inventory q in quotes away from the Target share, the
+// risk aversion Gamma, the order book liquidity Kappa and the variance of the
+// price over the Horizon from the book's history, all relative to the price,
+// the reservation price is
+//
+//	r = s * (1 - q*Gamma*variance)
+//
+// and the quotes are the spread
+//
+//	Gamma*variance + 2/Gamma*ln(1 + Gamma/Kappa)
+//
+// wide around it. The book holds Quotes sells and buys, each further one
+// Interval beyond the last, with the middle at the reservation price, and
+// moves them every tick.
+type Avellaneda struct {
+	ticker Ticker
+}
+
+func (a *Avellaneda) check(b *Book) error {
+	if b.conf.Gamma <= 0 || b.conf.Kappa <= 0 || b.Quantity <= 0 {
+		return errors.New("Avellaneda-Stoikov needs a positive Gamma, Kappa and Quantity")
+	}
+
+	// Without a history the variance is zero and the quotes never lean on
+	// the inventory
+	if b.conf.VolWindow < 3 {
+		return errors.New("Avellaneda-Stoikov needs a VolWindow of at least 3 ticks")
+	}
+
+	if b.Start <= 0 {
+		return errors.New("Avellaneda-Stoikov needs a positive Start to quote around")
+	}
+
+	return nil
+}
+
+func (a *Avellaneda) Build(b *Book, middle float64) ([]Order, error) {
+	if err := a.check(b); err != nil {
+		return nil, err
+	}
+
+	return a.quotes(b, middle, 0), nil
+}
+
+// quotes returns the levels of the book for the price and inventory q, from
+// the furthest sell to the furthest buy. Each quote spends Quantity of the
+// currency.
+func (a *Avellaneda) quotes(b *Book, price float64, q float64) []Order {
+	n := b.conf.Quotes
+	if n == 0 {
+		n = 1
+	}
+
+	horizon := b.conf.Horizon
+	if horizon == 0 {
+		horizon = b.conf.VolWindow
+	}
+
+	gamma := b.conf.Gamma
+	vol := b.volatility()
+	variance := vol * vol * float64(horizon)
+
+	reservation := price * (1 - q*gamma*variance)
+	spread := gamma*variance + 2/gamma*math.Log1p(gamma/b.conf.Kappa)
+
+	var orders []Order
+	for k := n - 1; k >= 0; k-- {
+		rate := reservation * (1 + spread/2) * (1 + float64(k)*b.Interval)
+		orders = append(orders, Order{Rate: rate, Quantity: b.Quantity / rate})
+	}
+
+	orders = append(orders, Order{Rate: reservation, Middle: true})
+
+	for k := 0; k < n; k++ {
+		rate := reservation * (1 - spread/2) * (1 - float64(k)*b.Interval)
+		if rate <= 0 {
+			break
+		}
+		orders = append(orders, Order{Buy: true, Rate: rate, Quantity: b.Quantity / rate})
+	}
+
+	return orders
+}
+
+// inventory returns how many quotes' worth of asset the book holds beyond
+// the Target share of its holdings, a half by default.
+func (a *Avellaneda) inventory(b *Book, price float64) (float64, error) {
+	asset, currency, err := b.holdings()
+	if err != nil {
+		return 0, err
+	}
+
+	target := b.conf.Target
+	if target == 0 {
+		target = 0.5
+	}
+
+	value := asset*price + currency
+
+	return (asset - target*value/price) / (b.Quantity / price), nil
+}
+
+func (a *Avellaneda) OnFills(b *Book, filled []int) error {
+	for _, i := range filled {
+		log.Printf("%s: quote at %f filled", b.Market, b.Orders[i].Rate)
+	}
+
+	return nil
+}
+
+func (a *Avellaneda) OnTicker(b *Book, ticker Ticker) error {
+	a.ticker = ticker
+
+	return a.check(b)
+}
+
+// Orders moves the quotes to the current price and inventory. A resting
+// quote within Tolerance, as a fraction of the rate, of where it should be is
+// left alone. Quotes are never placed where they would trade straight away.
+func (a *Avellaneda) Orders(b *Book) ([]Order, error) {
+	price := b.price(a.ticker)
+	if price <= 0 {
+		return append([]Order(nil), b.Orders...), nil
+	}
+
+	q, err := a.inventory(b, price)
+	if err != nil {
+		return nil, err
+	}
+
+	// A quote that would trade straight away is moved to the best price on
+	// its own side. If that side of the market is empty it keeps the rate of
+	// the quote it replaces, and with none the quotes are left as they are.
+	orders := a.quotes(b, price, q)
+	old := nearest(b.Orders)
+	for j, i := range nearest(orders) {
+		order := orders[i]
+
+		rate := order.Rate
+		if order.Buy && order.Rate >= a.ticker.Ask {
+			rate = a.ticker.Bid
+		} else if !order.Buy && order.Rate <= a.ticker.Bid {
+			rate = a.ticker.Ask
+		}
+		if rate <= 0 {
+			if j >= len(old) || b.Orders[old[j]].Buy != order.Buy || b.Orders[old[j]].Rate <= 0 {
+				return append([]Order(nil), b.Orders...), nil
+			}
+			rate = b.Orders[old[j]].Rate
+		}
+
+		orders[i].Rate = rate
+		orders[i].Quantity = b.Quantity / rate
+	}
+
+	// Match the quotes to the old ones on the same side and the same
+	// distance from the middle
+	for j, i := range nearest(orders) {
+		if j >= len(old) || orders[i].Buy != b.Orders[old[j]].Buy {
+			continue
+		}
+
+		o := b.Orders[old[j]]
+		if o.UID == "" || o.Filled {
+			continue
+		}
+
+		if math.Abs(o.Rate-orders[i].Rate) <= b.conf.Tolerance*orders[i].Rate {
+			orders[i] = o
+		} else {
+			orders[i].UID = o.UID
+		}
+	}
+
+	for i, order := range orders {
+		if order.UID == "" && !order.Middle {
+			orders[i].Filled = true
+		}
+	}
+
+	return orders, nil
+}
+
+// nearest returns the indices of the sells from the middle up followed by the
+// buys from the middle down.
+func nearest(orders []Order) []int {
+	middle := 0
+	for i, order := range orders {
+		if order.Middle {
+			middle = i
+		}
+	}
+
+	var indices []int
+	for i := middle - 1; i >= 0; i-- {
+		indices = append(indices, i)
+	}
+	for i := middle + 1; i < len(orders); i++ {
+		indices = append(indices, i)
+	}
+
+	return indices
+}
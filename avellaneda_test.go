@@ -0,0 +1,59 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEmptySide(t *testing.T) {
+	quiet(t)
+
+	m := Market{
+		Market: "VTCBTC", Start: 0.0024128, Quantity: 0.004, Strategy: "avellaneda",
+		Gamma: 0.1, Kappa: 1000, VolWindow: 3, Quotes: 2,
+	}
+	_, paper := testPaper(m)
+
+	b := testBook(t, m, paper, "")
+	for i := 0; i < 5; i++ {
+		if err := b.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// With no asks the sells are priced below the bid, where they would be
+	// moved to the empty ask
+	a := b.strategy.(*Avellaneda)
+	a.ticker = Ticker{Bid: m.Start, Ask: 0, Last: m.Start}
+	orders, err := a.Orders(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, order := range orders {
+		if order.Middle {
+			continue
+		}
+		if !(order.Rate > 0) || math.IsInf(order.Quantity, 0) || !(order.Quantity > 0) {
+			t.Fatalf("level %d: %f at %f", i, order.Quantity, order.Rate)
+		}
+		if !order.Buy && order.Rate != b.Orders[i].Rate {
+			t.Fatalf("sell %d moved from %f to %f", i, b.Orders[i].Rate, order.Rate)
+		}
+	}
+}
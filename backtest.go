@@ -0,0 +1,440 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trade is a single historical trade.
+type Trade struct {
+	Time     time.Time
+	Rate     float64
+	Quantity float64
+}
+
+var tradeTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339,
+}
+
+// LoadTrades reads a CSV dump of historical trades such as those exported by
+// Vertpig and Poloniex. The columns are found by name from the header row:
+// the time from Date, Time or TimeStamp, the rate from Rate or Price and the
+// quantity from Amount or Quantity. Times are either unix seconds or one of
+// the layouts above. The trades are returned in time order.
+func LoadTrades(filename string) ([]Trade, error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	r := csv.NewReader(in)
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	timeCol, rateCol, quantityCol := -1, -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "date", "time", "timestamp":
+			timeCol = i
+		case "rate", "price":
+			rateCol = i
+		case "amount", "quantity":
+			quantityCol = i
+		}
+	}
+	if timeCol < 0 || rateCol < 0 || quantityCol < 0 {
+		return nil, fmt.Errorf("%s: could not find time, rate and quantity columns in %v", filename, header)
+	}
+
+	var ret []Trade
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var t Trade
+		t.Time, err = parseTradeTime(record[timeCol])
+		if err != nil {
+			return nil, err
+		}
+
+		t.Rate, err = strconv.ParseFloat(record[rateCol], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Quantity, err = strconv.ParseFloat(record[quantityCol], 64)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, t)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Time.Before(ret[j].Time) })
+
+	return ret, nil
+}
+
+func parseTradeTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)).UTC(), nil
+	}
+
+	for _, layout := range tradeTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Unknown time format: %s", s)
+}
+
+// tape is a price feed that replays historical trades. The ticker is the
+// price of the current trade.
+type tape struct {
+	trades []Trade
+	pos    int
+}
+
+func (t *tape) Name() string {
+	return "tape"
+}
+
+func (t *tape) PlaceOrder(buy bool, market string, quantity float64, rate float64) (string, error) {
+	return "", errors.New("tape is read only")
+}
+
+func (t *tape) GetOrders(market string) ([]string, error) {
+	return nil, errors.New("tape is read only")
+}
+
+func (t *tape) CancelOrder(UID string) error {
+	return errors.New("tape is read only")
+}
+
+func (t *tape) GetTicker(market string) (Ticker, error) {
+	rate := t.trades[t.pos].Rate
+	return Ticker{rate, rate, rate}, nil
+}
+
+func (t *tape) GetBalance(asset string) (float64, error) {
+	return 0, errors.New("tape is read only")
+}
+
+type LevelFills struct {
+	Rate  float64
+	Buys  int
+	Sells int
+}
+
+type InventorySample struct {
+	Time     time.Time
+	Rate     float64
+	Asset    float64
+	Currency float64
+}
+
+type BacktestResult struct {
+	Market    Market
+	Trades    int
+	Fills     int
+	Levels    []LevelFills
+	Profit    float64
+	Fees      float64
+	Currency  float64
+	Asset     float64
+	Drawdown  float64
+	Inventory []InventorySample
+}
+
+// Backtest runs a book for m through trades, ticking it every tick of
+// simulated time. Orders fill in full as soon as a trade prints at or through
+// their rate and fee is charged on each fill. Profit is realized profit in
+// the market's currency using the average cost of the asset held, with the
+// asset the book starts with costed at the start price. Currency and Asset are
+// the most of each the book had committed at once. Drawdown is the largest
+// peak to trough fall in the value of the book's inventory, sampled hourly, as
+// a fraction of the peak. The book starts with twice the capital it asks
+// for, except under balance sizing, where its levels are sized from balances
+// and it starts with those.
+func Backtest(m Market, trades []Trade, tick time.Duration, fee float64, balances map[string]float64) (BacktestResult, error) {
+	res := BacktestResult{Market: m, Trades: len(trades)}
+	if len(trades) == 0 {
+		return res, errors.New("No trades to backtest")
+	}
+
+	if m.Start == 0 || m.relative() {
+		m.resolve(trades[0].Rate)
+		res.Market = m
+	}
+
+	if m.High <= m.Low || m.Low <= 0 || m.Interval <= 0 || m.Quantity <= 0 {
+		return res, fmt.Errorf("Market %s needs a High above a positive Low, an Interval and a Quantity", m.Market)
+	}
+
+	feed := &tape{trades: trades}
+	asset, currency := splitMarket(m.Market)
+
+	// The book is given more than it asks for so that its actual usage can be
+	// measured. Its capital is found from a book on an empty account, which
+	// balance sizing would leave with nothing on its levels.
+	if m.Sizing == "balance" {
+		if balances[asset] <= 0 && balances[currency] <= 0 {
+			return res, fmt.Errorf("Market %s uses balance sizing, which needs Paper balances of %s or %s to backtest", m.Market, asset, currency)
+		}
+		balances = map[string]float64{currency: balances[currency], asset: balances[asset]}
+	} else {
+		probe, err := newBook(m, NewPaper(feed, fee, nil, ""), "")
+		if err != nil {
+			return res, err
+		}
+		c, a := probe.Capital()
+		balances = map[string]float64{currency: 2 * c, asset: 2 * a}
+	}
+
+	ex := NewPaper(feed, fee, balances, "")
+	now := trades[0].Time
+	ex.now = func() time.Time { return now }
+
+	b, err := newBook(m, ex, "")
+	if err != nil {
+		return res, err
+	}
+	b.now = ex.now
+
+	needCurrency, needAsset := b.Capital()
+
+	minCurrency, minAsset := balances[currency], balances[asset]
+	position, cost := needAsset, needAsset*m.Start
+
+	levels := map[float64]*LevelFills{}
+	seen := 0
+
+	var next, sample time.Time
+	for i, t := range trades {
+		feed.pos = i
+		now = t.Time
+
+		if _, err := ex.GetTicker(m.Market); err != nil {
+			return res, err
+		}
+
+		if !now.Before(next) {
+			if err := b.Tick(); err != nil {
+				log.Printf("%v", err)
+			}
+			next = now.Add(tick)
+		}
+
+		for _, f := range ex.Fills[seen:] {
+			l, ok := levels[f.Rate]
+			if !ok {
+				l = &LevelFills{Rate: f.Rate}
+				levels[f.Rate] = l
+			}
+
+			if f.Buy {
+				l.Buys++
+				res.Fees += f.Fee * f.Rate
+				position += f.Quantity - f.Fee
+				cost += f.Quantity * f.Rate
+				continue
+			}
+
+			l.Sells++
+			res.Fees += f.Fee
+
+			// Only the asset costed in the position realizes a profit, any
+			// more sold came from the headroom the book was given
+			sold := math.Min(f.Quantity, position)
+			if sold > 0 {
+				avg := cost / position
+				res.Profit += sold*f.Rate - f.Fee*sold/f.Quantity - sold*avg
+				cost -= sold * avg
+				position -= sold
+			}
+		}
+		seen = len(ex.Fills)
+
+		if ex.Balances[currency] < minCurrency {
+			minCurrency = ex.Balances[currency]
+		}
+		if ex.Balances[asset] < minAsset {
+			minAsset = ex.Balances[asset]
+		}
+
+		if !now.Before(sample) || i == len(trades)-1 {
+			// Leave out the headroom the book was given on top of its capital.
+			s := InventorySample{Time: now, Rate: t.Rate, Asset: ex.Balances[asset] - needAsset, Currency: ex.Balances[currency] - needCurrency}
+			for _, o := range ex.Orders {
+				if o.Buy {
+					s.Currency += o.Quantity * o.Rate
+				} else {
+					s.Asset += o.Quantity
+				}
+			}
+			res.Inventory = append(res.Inventory, s)
+			sample = now.Add(time.Hour)
+		}
+	}
+
+	var peak float64
+	for _, s := range res.Inventory {
+		value := s.Asset*s.Rate + s.Currency
+		if value > peak {
+			peak = value
+		}
+		if peak > 0 && (peak-value)/peak > res.Drawdown {
+			res.Drawdown = (peak - value) / peak
+		}
+	}
+
+	res.Fills = len(ex.Fills)
+	res.Currency = balances[currency] - minCurrency
+	res.Asset = balances[asset] - minAsset
+
+	for _, l := range levels {
+		res.Levels = append(res.Levels, *l)
+	}
+	sort.Slice(res.Levels, func(i, j int) bool { return res.Levels[i].Rate > res.Levels[j].Rate })
+
+	return res, nil
+}
+
+// backtest runs a market from config.json through a CSV dump of trades and
+// prints a report.
+func backtest(args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	trades := fs.String("trades", "", "CSV file of historical trades")
+	market := fs.String("market", "", "market in config.json to test")
+	high := fs.Float64("high", 0, "override High")
+	low := fs.Float64("low", 0, "override Low")
+	start := fs.Float64("start", 0, "override Start")
+	interval := fs.Float64("interval", 0, "override Interval")
+	quantity := fs.Float64("quantity", 0, "override Quantity")
+	tick := fs.Duration("tick", 3*time.Second, "simulated time between book ticks")
+	fee := fs.Float64("fee", 0.0025, "maker fee")
+	verbose := fs.Bool("v", false, "log every book action")
+	fs.Parse(args)
+
+	m, balances, err := configMarket(*market)
+	if err != nil {
+		return err
+	}
+
+	if *high != 0 {
+		m.High = *high
+	}
+	if *low != 0 {
+		m.Low = *low
+	}
+	if *start != 0 {
+		m.Start = *start
+	}
+	if *interval != 0 {
+		m.Interval = *interval
+	}
+	if *quantity != 0 {
+		m.Quantity = *quantity
+	}
+
+	t, err := LoadTrades(*trades)
+	if err != nil {
+		return err
+	}
+
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	res, err := Backtest(m, t, *tick, *fee, balances)
+	if err != nil {
+		return err
+	}
+
+	printBacktest(res)
+
+	return nil
+}
+
+// configMarket returns the entry for market in config.json or, if there is
+// none, an empty entry for it, and the Paper balances.
+func configMarket(market string) (Market, map[string]float64, error) {
+	if market == "" {
+		return Market{}, nil, errors.New("No market given")
+	}
+
+	var conf Config
+	if err := LoadStruct("./config.json", &conf); err != nil && !os.IsNotExist(err) {
+		return Market{}, nil, err
+	}
+
+	for _, m := range conf.Markets {
+		if m.Market == market {
+			return m, conf.Paper.Balances, nil
+		}
+	}
+
+	return Market{Market: market}, conf.Paper.Balances, nil
+}
+
+func printBacktest(res BacktestResult) {
+	asset, currency := splitMarket(res.Market.Market)
+
+	fmt.Printf("%+v\n", res.Market)
+	fmt.Printf("Trades: %d, Fills: %d\n", res.Trades, res.Fills)
+	fmt.Printf("Realized profit: %s %f, Fees: %s %f\n", currency, res.Profit, currency, res.Fees)
+	fmt.Printf("Capital required: %s %f, %s %f\n", currency, res.Currency, asset, res.Asset)
+	fmt.Printf("Max drawdown: %.2f%%\n", res.Drawdown*100)
+
+	fmt.Printf("\nFills per level:\n%12s %6s %6s\n", "Rate", "Buys", "Sells")
+	for _, l := range res.Levels {
+		fmt.Printf("%12.8f %6d %6d\n", l.Rate, l.Buys, l.Sells)
+	}
+
+	fmt.Printf("\nInventory:\n%-20s %16s %16s\n", "Time", asset, currency)
+	for _, s := range res.Inventory {
+		fmt.Printf("%-20s %16.8f %16.8f\n", s.Time.Format("2006-01-02 15:04:05"), s.Asset, s.Currency)
+	}
+}
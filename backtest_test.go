@@ -0,0 +1,73 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// path returns trades that walk the price in straight lines through points,
+// one trade a minute.
+func path(points ...float64) []Trade {
+	var trades []Trade
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i < len(points); i++ {
+		for j := 0; j < 20; j++ {
+			rate := points[i-1] + (points[i]-points[i-1])*float64(j)/20
+			trades = append(trades, Trade{now, rate, 1})
+			now = now.Add(time.Minute)
+		}
+	}
+	return trades
+}
+
+func TestBacktestBalanceSizing(t *testing.T) {
+	quiet(t)
+
+	m := testMarket()
+	m.Sizing, m.Quantity = "balance", 0.5
+
+	// A high fee makes the round trips lose asset, so that the rise sells
+	// more than the book is counted as holding
+	points := []float64{m.Start, m.Start * 0.9}
+	for i := 0; i < 20; i++ {
+		points = append(points, m.Start*0.95, m.Start*0.9)
+	}
+	points = append(points, m.Start*1.8)
+	trades := path(points...)
+
+	if _, err := Backtest(m, trades, time.Minute, 0.05, nil); err == nil {
+		t.Fatal("balance sizing backtested without balances")
+	}
+
+	res, err := Backtest(m, trades, time.Minute, 0.05, map[string]float64{"VTC": 1000, "BTC": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Fills == 0 || res.Currency <= 0 || res.Asset <= 0 {
+		t.Fatalf("%d fills needing BTC %f and VTC %f", res.Fills, res.Currency, res.Asset)
+	}
+
+	fills := 0
+	for _, l := range res.Levels {
+		fills += l.Buys + l.Sells
+	}
+	if fills != res.Fills {
+		t.Fatalf("%d fills counted on the levels, %d made", fills, res.Fills)
+	}
+}
@@ -19,6 +19,10 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Order struct {
@@ -28,218 +32,679 @@ type Order struct {
 	Rate     float64
 	Filled   bool
 	Middle   bool
+	Flipped  bool
+	Virtual  bool
+
+	// Level and Size are the rate and quantity of the level before it was
+	// skewed, zero when it is not.
+	Level float64
+	Size  float64
+
+	// Pair is the UID of the fill whose other half this order is and Opened
+	// the rate it filled at, empty when the order is not paired.
+	Pair   string
+	Opened float64
 }
 
-type Book struct {
-	Orders   []Order
+// Fill is an order of a book that filled.
+type Fill struct {
 	Market   string
-	High     float64
-	Low      float64
-	Start    float64
-	Interval float64
-	Ex       Exchange
-	FirstRun bool
+	Buy      bool
+	Quantity float64
+	Rate     float64
 }
 
-func NewBook(market string, high float64, low float64, start float64, interval float64, quantity float64, exchange Exchange) *Book {
-	b := &Book{nil, market, high, low, start, interval, exchange, true}
+type Book struct {
+	Orders     []Order
+	Market     string
+	High       float64
+	Low        float64
+	Start      float64
+	Interval   float64
+	Quantity   float64
+	Spacing    string
+	Levels     []float64
+	Sizing     string
+	SizeFactor float64
+	Exit       string
+	Shift      int
+	Prices     []float64
+	Trips      []Trip
+	Profile    string
+	Strategy   string
+	Built      Params
+	Ex         Exchange `json:"-"`
+	FirstRun   bool
+	Peak       float64
+	Asset      float64
+	Currency   float64
+	Position   float64
+	Proceeds   float64
+	Tripped    bool
+	Calm       int
+	Halted     bool
+	Reason     string
+
+	conf      Market
+	strategy  Strategy
+	listeners []func(Fill)
+	alloc     *Allocator
+	rules     []rule
+	profile   Profile
+	now       func() time.Time
+	reference *Reference
+	ref       float64
+	quote     *Reference
+	held      string
+	empty     int
+	mu        sync.Mutex
+	ticking   bool
+	path      string
+	assert    bool
+}
 
-	var err error
-	switch exchange.Name() {
+// emptyTicks is how many ticks in a row the exchange must report no open
+// orders while the book has both buys and sells resting before it is
+// believed.
+const emptyTicks = 3
+
+// bookFile returns the file a book for market on the named exchange is
+// persisted to.
+func bookFile(exchange string, market string) string {
+	switch exchange {
 	case "poloniex":
-		err = LoadStruct("./polobook"+market, b)
+		return "./polobook" + market
 	case "vertpig":
-		err = LoadStruct("./vpbook"+market, b)
+		return "./vpbook" + market
+	}
+	return "./" + exchange + "book" + market
+}
+
+// splitMarket returns the asset and the currency it is priced in for market.
+// Poloniex style markets are written CURRENCY_ASSET, Vertpig style markets
+// are written ASSETCURRENCY with a three letter asset.
+func splitMarket(market string) (asset string, currency string) {
+	if i := strings.Index(market, "_"); i >= 0 {
+		return market[i+1:], market[:i]
 	}
+	return market[:3], market[3:]
+}
+
+func NewBook(m Market, exchange Exchange) (*Book, error) {
+	b, err := newBook(m, exchange, bookFile(exchange.Name(), m.Market))
 	if err != nil {
-		log.Printf("%v", err)
+		return nil, err
+	}
 
-		midFound := false
-		for i := high; i >= low; i -= interval * start {
-			if !midFound {
-				if i <= start {
-					b.Orders = append(b.Orders, Order{"", false, quantity / i, i, false, true})
-					midFound = true
-				} else {
-					b.Orders = append(b.Orders, Order{"", false, quantity / i, i, false, false})
-				}
-			} else {
-				b.Orders = append(b.Orders, Order{"", true, quantity / i, i, false, false})
-			}
+	// Warn when the exchange does not hold enough to place the levels that
+	// have no order resting yet. The book still starts so that one short
+	// market does not stop the others, and Tick reports it until it is
+	// funded.
+	reqCurrency, reqAsset := b.required()
+	asset, currency := splitMarket(b.Market)
+	for _, need := range []struct {
+		name string
+		req  float64
+	}{{asset, reqAsset}, {currency, reqCurrency}} {
+		bal, err := exchange.GetBalance(need.name)
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		if bal < need.req {
+			log.Printf("%s: %s sizing needs %s %f to place its orders, have %f", b.Market, sizing(b.Sizing), need.name, need.req, bal)
 		}
 	}
 
-	var currency, asset float64
-	for _, order := range b.Orders {
-		if !order.Middle {
-			if order.Buy {
-				currency += order.Quantity * order.Rate
-			} else {
-				asset += order.Quantity
-			}
+	return b, nil
+}
+
+// newBook is NewBook with the book persisted to path. If path is empty the
+// book is built fresh and kept in memory only.
+func newBook(m Market, exchange Exchange, path string) (*Book, error) {
+	b, loaded, err := openBook(m, exchange, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if loaded {
+		if changes := b.changes(m); len(changes) > 0 {
+			log.Printf("%s: config changed, keeping the book's parameters until mmbot migrate -apply is run: %s", m.Market, strings.Join(changes, ", "))
+			b.conf = b.unchanged(m)
 		}
 	}
 
-	b.Ex = exchange
+	b.activate(b.Orders)
+	b.limit(b.Orders)
+
+	currency, asset := b.Capital()
 
-	log.Printf("Market: %s, Currency: %f, Asset: %f, # orders: %d", market, currency, asset, len(b.Orders))
+	log.Printf("Market: %s, Currency: %f, Asset: %f, # orders: %d", m.Market, currency, asset, len(b.Orders))
 
-	return b
+	return b, nil
 }
 
-func (b *Book) Tick() error {
-	// Update order statuses (filled)
+// openBook loads the book for m from path, or builds it if there is none,
+// without applying any change to the config of a loaded book.
+func openBook(m Market, exchange Exchange, path string) (*Book, bool, error) {
+	b := &Book{
+		Market:     m.Market,
+		High:       m.High,
+		Low:        m.Low,
+		Start:      m.Start,
+		Interval:   m.Interval,
+		Quantity:   m.Quantity,
+		Spacing:    m.Spacing,
+		Levels:     m.Levels,
+		Sizing:     m.Sizing,
+		SizeFactor: m.SizeFactor,
+		Exit:       m.Exit,
+		Strategy:   m.Strategy,
+		Ex:         exchange,
+		FirstRun:   true,
+		conf:       m,
+		now:        time.Now,
+		path:       path,
+		assert:     m.Assert,
+	}
 
-	defer func() {
-		var err error
-		switch b.Ex.Name() {
-		case "poloniex":
-			err = SaveStruct("./polobook"+b.Market, b)
-		case "vertpig":
-			err = SaveStruct("./vpbook"+b.Market, b)
-		}
+	loaded := false
+	if path != "" {
+		err := LoadStruct(path, b)
 		if err != nil {
 			log.Printf("%v", err)
+		} else {
+			loaded = true
 		}
-	}()
+	}
 
-	open, err := b.Ex.GetOrders(b.Market)
+	// The exit policy does not change the ladder so always follows the config
+	b.Exit = m.Exit
+
+	var err error
+	if m.Reference != nil {
+		b.reference, err = NewReference(*m.Reference, exchange)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if m.Risk != nil && m.Risk.Quote != nil {
+		b.quote, err = NewReference(*m.Risk.Quote, exchange)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, r := range m.Schedule {
+		c, err := ParseCron(r.Cron)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, ok := m.Profiles[r.Profile]; !ok {
+			return nil, false, fmt.Errorf("%s: unknown profile %s", m.Market, r.Profile)
+		}
+		b.rules = append(b.rules, rule{c, r.Profile})
+	}
+
+	b.strategy, err = NewStrategy(b.Strategy)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	openOrders := map[string]bool{}
-	for _, uid := range open {
-		openOrders[uid] = true
+	if loaded {
+		// Books persisted before the config they were built from was kept
+		// were built from their own parameters
+		if b.Built == (Params{}) {
+			b.Built = b.params()
+		}
+		return b, true, nil
 	}
 
-	filledOne := false
-	for i, order := range b.Orders {
-		if _, ok := openOrders[order.UID]; !ok && !order.Middle {
-			b.Orders[i].Filled = true
-			filledOne = true
+	if m.relative() {
+		if err := b.resolve(); err != nil {
+			return nil, false, err
 		}
 	}
+	b.Built = b.conf.params()
 
-	if filledOne && !b.FirstRun {
-		// Get price
+	b.Orders, err = b.strategy.Build(b, b.Start)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, false, nil
+}
+
+// resolve fixes a range given relative to the market's price at its current
+// price. The book persists the result so the range stays where it was first
+// built.
+func (b *Book) resolve() error {
+	var price float64
+	if b.reference != nil {
+		var err error
+		price, err = b.reference.Price()
+		if err != nil {
+			return err
+		}
+	} else {
 		ticker, err := b.Ex.GetTicker(b.Market)
 		if err != nil {
 			return err
 		}
+		price = (ticker.Bid + ticker.Ask) / 2
+	}
 
-		price := (ticker.Ask + ticker.Bid) / 2
+	b.conf.resolve(price)
+	b.Start, b.High, b.Low = b.conf.Start, b.conf.High, b.conf.Low
+	if b.High <= b.Low {
+		return fmt.Errorf("%s: High %f is not above Low %f", b.Market, b.High, b.Low)
+	}
 
-		// Set new midpoint
-		log.Printf("Current price: %f", price)
+	log.Printf("%s: starting at %f between %f and %f", b.Market, b.Start, b.Low, b.High)
 
-		orig := 0
-		for i, order := range b.Orders {
-			if order.Middle {
-				orig = i
-				b.Orders[i].Middle = false
-				break
-			}
+	return nil
+}
+
+// spacing returns the name of a spacing mode, books persisted before the mode
+// could be chosen are arithmetic.
+func spacing(mode string) string {
+	if mode == "" {
+		return "arithmetic"
+	}
+	return mode
+}
+
+func sameRates(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sizing returns the name of a sizing mode, books persisted before the mode
+// could be chosen size in currency.
+func sizing(mode string) string {
+	if mode == "" {
+		return "quote"
+	}
+	return mode
+}
+
+// setMiddle makes the first level at or below rate the middle, or the last
+// level if there is none, with sells above it and buys below it.
+func setMiddle(orders []Order, rate float64) {
+	middle := len(orders) - 1
+	for i, order := range orders {
+		if order.Rate <= rate {
+			middle = i
+			break
+		}
+	}
+
+	for i := range orders {
+		orders[i].Middle = i == middle
+		orders[i].Buy = i > middle
+	}
+}
+
+// middle returns the rate of the middle level of the book, or Start if it
+// has none.
+func (b *Book) middle() float64 {
+	for _, order := range b.Orders {
+		if order.Middle {
+			return order.Rate
 		}
+	}
+	return b.Start
+}
 
-		log.Printf("Old middle: %v", orig)
+// same reports whether two rates or quantities are equal but for rounding.
+func same(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
 
-		middle := orig
-		for i, order := range b.Orders {
-			run := false
-			if order.Filled && i < orig {
-				log.Printf("Setting middle at %d, price: %f because it's the first filled order we saw", i, order.Rate)
-				run = true
-			} else if i+1 < len(b.Orders) {
-				if !b.Orders[i+1].Filled && order.Filled {
-					log.Printf("Setting middle at %d, price: %f because the next order is unfilled", i, order.Rate)
+// migrate rebuilds the levels of the book from its current parameters with
+// the middle at the first level at or below the rate middle. Resting orders
+// with the same side, rate and quantity as a new level are kept, the others
+// are cancelled, and the new levels are placed on the next placement.
+func (b *Book) migrate(middle float64) error {
+	orders, err := b.plan(middle)
+	if err != nil {
+		return err
+	}
 
-					run = true
+	b.reconcile(orders)
+	b.FirstRun = true
 
-					for j := i + 1; j < len(b.Orders); j++ {
-						if b.Orders[j].Filled {
-							log.Printf("Actually scratch that, there is a later filled order at %d", j)
-							run = false
-							break
-						}
-					}
-				}
-			}
+	return nil
+}
+
+// plan returns the levels migrate would rebuild the book to, with the
+// resting orders it keeps in place and the levels it places marked Filled.
+func (b *Book) plan(middle float64) ([]Order, error) {
+	orders, err := b.strategy.Build(b, middle)
+	if err != nil {
+		return nil, err
+	}
 
-			if run {
-				middle = i
+	for _, old := range b.Orders {
+		if old.UID == "" || old.Filled || old.Middle {
+			continue
+		}
+		for i, order := range orders {
+			if order.UID == "" && !order.Middle && order.Buy == old.Buy && same(order.Rate, old.Rate) && same(order.Quantity, old.Quantity) {
+				orders[i] = old
 				break
 			}
 		}
+	}
 
-		log.Printf("New middle: %d", middle)
+	for i, order := range orders {
+		if order.UID == "" && !order.Middle {
+			orders[i].Filled = true
+		}
+	}
 
-		b.Orders[middle].Middle = true
+	return orders, nil
+}
 
-		for i, order := range b.Orders {
-			if order.Filled {
-				if i <= middle {
-					b.Orders[i].Buy = false
-				} else {
-					b.Orders[i].Buy = true
-				}
+// Capital returns the amount of currency and asset needed to place every
+// order in the book.
+func (b *Book) Capital() (currency float64, asset float64) {
+	for _, order := range b.Orders {
+		if !order.Middle {
+			if order.Buy {
+				currency += order.Quantity * order.Rate
+			} else {
+				asset += order.Quantity
 			}
-			//log.Printf("%+v", b.Orders[i])
 		}
 	}
 
-	// Check we have enough balance for the orders we want to place
+	return currency, asset
+}
 
-	var reqAsset, reqCurrency float64
+// required returns the amount of currency and asset needed to place the
+// levels that have no order resting and are not held virtually.
+func (b *Book) required() (currency float64, asset float64) {
 	for _, order := range b.Orders {
-		if order.Filled && !order.Middle {
+		if !order.Middle && !order.Virtual && (order.Filled || order.UID == "") {
 			if order.Buy {
-				reqCurrency += order.Quantity * order.Rate
+				currency += order.Quantity * order.Rate
 			} else {
-				reqAsset += order.Quantity
+				asset += order.Quantity
 			}
 		}
 	}
 
-	var asset string
-	var currency string
-	switch b.Ex.Name() {
-	case "poloniex":
-		asset = b.Market[4:]
-		currency = b.Market[:3]
-	case "vertpig":
-		asset = b.Market[:3]
-		currency = b.Market[3:]
+	return currency, asset
+}
+
+// Listen calls f with every order of the book that fills.
+func (b *Book) Listen(f func(Fill)) {
+	b.listeners = append(b.listeners, f)
+}
+
+// price returns the reference price of the market if it has one, otherwise
+// the middle of the bid and ask of ticker.
+func (b *Book) price(ticker Ticker) float64 {
+	if b.reference != nil {
+		return b.ref
+	}
+	return (ticker.Bid + ticker.Ask) / 2
+}
+
+// holdings returns the asset and currency on the exchange, including what
+// is held in the book's resting orders.
+func (b *Book) holdings() (asset float64, currency float64, err error) {
+	a, c := splitMarket(b.Market)
+	asset, err = b.Ex.GetBalance(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	currency, err = b.Ex.GetBalance(c)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	restingCurrency, restingAsset := b.resting()
+
+	return asset + restingAsset, currency + restingCurrency, nil
+}
+
+// resting returns the currency and asset held in the book's resting orders.
+func (b *Book) resting() (currency float64, asset float64) {
+	for _, order := range b.Orders {
+		if order.UID != "" && !order.Filled && !order.Middle {
+			if order.Buy {
+				currency += order.Quantity * order.Rate
+			} else {
+				asset += order.Quantity
+			}
+		}
+	}
+
+	return currency, asset
+}
+
+// save persists the book to its path, if it has one.
+func (b *Book) save() {
+	if b.path == "" {
+		return
+	}
+
+	if err := SaveStruct(b.path, b); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+func (b *Book) Tick() error {
+	// A tick that outlasts the interval between ticks must not overlap the
+	// next, which would see and hedge the same fills again
+	b.mu.Lock()
+	if b.ticking {
+		b.mu.Unlock()
+		return fmt.Errorf("%s: previous tick still running, skipping tick", b.Market)
+	}
+	b.ticking = true
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.ticking = false
+		b.mu.Unlock()
+	}()
+
+	if b.Halted {
+		return fmt.Errorf("%s is halted: %s", b.Market, b.Reason)
+	}
+
+	defer func() {
+		if b.assert {
+			if err := b.Check(); err != nil {
+				log.Printf("%s: invariant broken, halting: %v", b.Market, err)
+				b.Halted = true
+				b.Reason = err.Error()
+			}
+		}
+
+		b.save()
+	}()
+
+	// Update order statuses (filled)
+
+	open, err := b.Ex.GetOrders(b.Market)
+	if err != nil {
+		return err
+	}
+
+	openOrders := map[string]bool{}
+	for _, uid := range open {
+		openOrders[uid] = true
+	}
+
+	// Resting buys and sells rarely all fill at once, so an empty response
+	// while we have both is most likely the exchange failing to report our
+	// orders. It is taken as real once it has lasted emptyTicks ticks, as
+	// when the orders were cancelled by hand or purged by the exchange, and
+	// the orders are placed again where they were rather than taken as fills.
+	gone := false
+	if len(open) == 0 {
+		var buys, sells bool
+		for _, order := range b.Orders {
+			if !order.Filled && !order.Middle && order.UID != "" {
+				buys = buys || order.Buy
+				sells = sells || !order.Buy
+			}
+		}
+		if buys && sells {
+			b.empty++
+			if b.empty < emptyTicks {
+				return fmt.Errorf("%s: no open orders reported while buys and sells are resting, skipping tick", b.Market)
+			}
+			log.Printf("%s: no open orders reported for %d ticks, placing the orders again", b.Market, b.empty)
+			gone = true
+		}
+	}
+	b.empty = 0
+
+	var filled []int
+	for i, order := range b.Orders {
+		if order.Virtual && order.UID == "" {
+			continue
+		}
+		if _, ok := openOrders[order.UID]; !ok && !order.Middle {
+			if gone {
+				b.Orders[i].UID = ""
+			} else if !order.Filled && order.UID != "" {
+				filled = append(filled, i)
+			}
+			b.Orders[i].Filled = true
+		}
 	}
-	assetBal, err := b.Ex.GetBalance(asset)
+
+	b.count(filled)
+
+	for _, i := range filled {
+		for _, f := range b.listeners {
+			f(Fill{b.Market, b.Orders[i].Buy, b.Orders[i].Quantity, b.Orders[i].Rate})
+		}
+	}
+
+	// Get price
+	ticker, err := b.Ex.GetTicker(b.Market)
 	if err != nil {
 		return err
 	}
 
-	if assetBal < reqAsset {
-		return fmt.Errorf("Not enough asset to place orders. Wanted: %s %f, Have: %s %f", asset, reqAsset, asset, assetBal)
+	b.breaker(ticker)
+	b.record(ticker)
+
+	if b.reference != nil {
+		b.ref, err = b.reference.Price()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(filled) > 0 {
+		log.Printf("Current price: %f", (ticker.Ask+ticker.Bid)/2)
+	}
+
+	if !b.FirstRun {
+		if err := b.strategy.OnFills(b, filled); err != nil {
+			return err
+		}
+	}
+
+	// A tripped breaker means the price can't be trusted, so it is not used
+	// to value the book either
+	if b.Tripped {
+		return fmt.Errorf("%s: circuit breaker tripped, not placing orders", b.Market)
+	}
+
+	if err := b.drawdown(ticker); err != nil {
+		return err
+	}
+
+	// Don't place orders while the market is away from its reference
+	if b.reference != nil && b.conf.Reference.MaxDeviation > 0 {
+		if mid := (ticker.Bid + ticker.Ask) / 2; math.Abs(mid-b.ref) > b.conf.Reference.MaxDeviation*b.ref {
+			return fmt.Errorf("%s: price %f is too far from the reference %f, not placing orders", b.Market, mid, b.ref)
+		}
+	}
+
+	if err := b.schedule(); err != nil {
+		return err
+	}
+
+	if err := b.strategy.OnTicker(b, ticker); err != nil {
+		return err
 	}
 
-	currencyBal, err := b.Ex.GetBalance(currency)
+	orders, err := b.strategy.Orders(b)
 	if err != nil {
 		return err
 	}
 
-	if currencyBal < reqCurrency {
-		return fmt.Errorf("Not enough currency to place orders. Wanted: %s%f, Have: %s%f", currency, reqCurrency, currency, currencyBal)
+	b.activate(orders)
+	b.limit(orders)
+	b.reconcile(orders)
+
+	// Check we have enough balance for the orders we want to place
+
+	reqCurrency, reqAsset := b.required()
+
+	asset, currency := splitMarket(b.Market)
+	if b.alloc != nil {
+		usedCurrency, usedAsset := b.resting()
+
+		if err := b.alloc.Reserve(b, asset, usedAsset, reqAsset); err != nil {
+			return err
+		}
+		defer b.alloc.Release(b)
+
+		if err := b.alloc.Reserve(b, currency, usedCurrency, reqCurrency); err != nil {
+			return err
+		}
+	} else {
+		assetBal, err := b.Ex.GetBalance(asset)
+		if err != nil {
+			return err
+		}
+
+		if assetBal < reqAsset {
+			return fmt.Errorf("Not enough asset to place orders. Wanted: %s %f, Have: %s %f", asset, reqAsset, asset, assetBal)
+		}
+
+		currencyBal, err := b.Ex.GetBalance(currency)
+		if err != nil {
+			return err
+		}
+
+		if currencyBal < reqCurrency {
+			return fmt.Errorf("Not enough currency to place orders. Wanted: %s%f, Have: %s%f", currency, reqCurrency, currency, currencyBal)
+		}
 	}
 
 	// re-submit filled orders
 	for i, order := range b.Orders {
-		if order.Filled && !order.Middle {
+		if order.Filled && !order.Middle && !order.Virtual {
 		TryAgain:
 			order = b.Orders[i]
 			uid, err := b.Ex.PlaceOrder(order.Buy, b.Market, order.Quantity, order.Rate)
 			if err != nil {
 				log.Printf("%+v", err)
 
-				if err.Error() == "POST_ONLY_FAILED" {
+				if err.Error() == "POST_ONLY_FAILED" && !b.Orders[i].Flipped {
 					b.Orders[i].Buy = !b.Orders[i].Buy
+					b.Orders[i].Flipped = true
 					goto TryAgain
 				}
 
@@ -257,3 +722,100 @@ func (b *Book) Tick() error {
 
 	return nil
 }
+
+// activate keeps only the Window levels either side of the middle of orders
+// on the exchange, or every level if the market has no Window, and none while
+// the book's profile is paused. The others
+// are held virtually: reconcile cancels any order resting on them and they
+// are not placed until the middle comes near.
+func (b *Book) activate(orders []Order) {
+	window := b.conf.Window
+	if b.profile.Window != 0 {
+		window = b.profile.Window
+	}
+	if window == 0 {
+		window = len(orders)
+	}
+	if b.profile.Paused {
+		window = -1
+	}
+
+	middle := 0
+	for i, order := range orders {
+		if order.Middle {
+			middle = i
+		}
+	}
+
+	for i, order := range orders {
+		if order.Middle {
+			continue
+		}
+
+		if i >= middle-window && i <= middle+window {
+			if order.Virtual {
+				orders[i].Virtual = false
+				orders[i].Filled = true
+			}
+			continue
+		}
+
+		orders[i].Virtual = true
+		if order.UID == "" || order.Filled {
+			orders[i].UID = ""
+			orders[i].Filled = false
+		}
+	}
+}
+
+// reconcile replaces the levels of the book with orders, cancelling resting
+// orders that are no longer wanted as they were and marking the levels that
+// replace them for placement.
+func (b *Book) reconcile(orders []Order) {
+	resting := map[string]Order{}
+	for _, order := range b.Orders {
+		if order.UID != "" && !order.Filled && !order.Middle {
+			resting[order.UID] = order
+		}
+	}
+
+	kept := map[string]bool{}
+	for i, order := range orders {
+		old, ok := resting[order.UID]
+		if !ok {
+			continue
+		}
+		kept[order.UID] = true
+
+		if !order.Filled && !order.Middle && !order.Virtual && old.Buy == order.Buy && old.Rate == order.Rate && old.Quantity == order.Quantity {
+			continue
+		}
+
+		if err := b.Ex.CancelOrder(order.UID); err != nil {
+			// Keep the order as it is and try again next tick
+			log.Printf("%+v", err)
+			orders[i] = old
+			continue
+		}
+
+		log.Printf("Cancelled Order: %+v", old)
+
+		orders[i].UID = ""
+		orders[i].Filled = !order.Virtual
+	}
+
+	for uid, old := range resting {
+		if kept[uid] {
+			continue
+		}
+
+		if err := b.Ex.CancelOrder(uid); err != nil {
+			log.Printf("%+v", err)
+			continue
+		}
+
+		log.Printf("Cancelled Order: %+v", old)
+	}
+
+	b.Orders = orders
+}
@@ -0,0 +1,84 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// BreakerConfig stops a market placing orders when its price moves more than
+// MaxJump, a fraction, away from the median of the Window ticks before it.
+// Placing resumes once the price has stayed within MaxJump of that median for
+// Settle ticks in a row, or only when the book is resumed if Settle is zero.
+type BreakerConfig struct {
+	MaxJump float64
+	Window  int
+	Settle  int
+}
+
+// window returns the number of ticks the median is taken over, 10 if it is
+// not set.
+func (c BreakerConfig) window() int {
+	if c.Window == 0 {
+		return 10
+	}
+	return c.Window
+}
+
+// breaker trips the book's circuit breaker when the price of ticker jumps
+// away from the prices before it and resets it once the price settles. It is
+// called before ticker is recorded.
+func (b *Book) breaker(ticker Ticker) {
+	conf := b.conf.Breaker
+	if conf == nil || conf.MaxJump <= 0 || len(b.Prices) < conf.window() {
+		return
+	}
+
+	centre := median(b.Prices[len(b.Prices)-conf.window():])
+	price := (ticker.Bid + ticker.Ask) / 2
+	move := math.Abs(price-centre) / centre
+
+	if move > conf.MaxJump {
+		if !b.Tripped {
+			log.Printf("%s: price %f is %.1f%% away from the median %f, circuit breaker tripped", b.Market, price, 100*move, centre)
+		}
+		b.Tripped, b.Calm = true, 0
+		return
+	}
+
+	if b.Tripped && conf.Settle > 0 {
+		b.Calm++
+		if b.Calm >= conf.Settle {
+			log.Printf("%s: price settled at %f, circuit breaker reset", b.Market, price)
+			b.Tripped, b.Calm = false, 0
+		}
+	}
+}
+
+// median returns the median of prices.
+func median(prices []float64) float64 {
+	sorted := append([]float64(nil), prices...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
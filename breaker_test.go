@@ -0,0 +1,58 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestBadPrint(t *testing.T) {
+	quiet(t)
+
+	m := testMarket()
+	m.Breaker = &BreakerConfig{MaxJump: 0.05, Window: 3, Settle: 2}
+	m.Risk = &RiskConfig{MaxDrawdown: 0.1}
+	feed, paper := testPaper(m)
+
+	b := testBook(t, m, paper, "")
+	for i := 0; i < 5; i++ {
+		if err := b.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A single print at half the price trips the breaker but must not be
+	// taken as a loss
+	feed.price = m.Start / 2
+	if err := b.Tick(); err == nil {
+		t.Fatal("the bad print did not trip the breaker")
+	}
+	if b.Halted {
+		t.Fatalf("the bad print halted the book: %s", b.Reason)
+	}
+
+	feed.price = m.Start
+	for i := 0; i < m.Breaker.Settle; i++ {
+		b.Tick()
+	}
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Halted || b.Tripped {
+		t.Fatal("the book did not go back to trading after the bad print")
+	}
+}
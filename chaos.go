@@ -0,0 +1,174 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosConfig sets the faults a Chaos exchange injects. Rates are the
+// fraction of calls affected and times are in seconds.
+type ChaosConfig struct {
+	// Latency is the most extra delay added to every call.
+	Latency float64
+
+	// Timeouts fail calls with a timeout after TimeoutAfter without making
+	// them.
+	Timeouts     float64
+	TimeoutAfter float64
+
+	// Errors fail calls without making them.
+	Errors float64
+
+	// EmptyOrders makes GetOrders report no open orders.
+	EmptyOrders float64
+
+	// StaleTickers makes GetTicker return the previous ticker for the market.
+	StaleTickers float64
+
+	// LostPlacements places the order but reports an error, so the caller
+	// never learns its UID.
+	LostPlacements float64
+
+	Seed int64
+}
+
+// Chaos wraps an Exchange and injects faults into the calls made through it.
+type Chaos struct {
+	Conf ChaosConfig
+
+	// Lost holds the UIDs of orders placed by LostPlacements faults.
+	Lost []string
+
+	ex      Exchange
+	rnd     *rand.Rand
+	tickers map[string]Ticker
+	sleep   func(time.Duration)
+	mu      sync.Mutex
+}
+
+func NewChaos(exchange Exchange, conf ChaosConfig) *Chaos {
+	return &Chaos{
+		Conf:    conf,
+		ex:      exchange,
+		rnd:     rand.New(rand.NewSource(conf.Seed)),
+		tickers: map[string]Ticker{},
+		sleep:   time.Sleep,
+	}
+}
+
+func (c *Chaos) roll(rate float64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rnd.Float64() < rate
+}
+
+// fault delays the call and returns the error it should fail with, if any.
+func (c *Chaos) fault() error {
+	c.mu.Lock()
+	delay := time.Duration(c.Conf.Latency * c.rnd.Float64() * float64(time.Second))
+	c.mu.Unlock()
+
+	c.sleep(delay)
+
+	if c.roll(c.Conf.Timeouts) {
+		c.sleep(time.Duration(c.Conf.TimeoutAfter * float64(time.Second)))
+		return errors.New("chaos: timeout")
+	}
+
+	if c.roll(c.Conf.Errors) {
+		return errors.New("chaos: injected error")
+	}
+
+	return nil
+}
+
+func (c *Chaos) Name() string {
+	return c.ex.Name()
+}
+
+func (c *Chaos) PlaceOrder(buy bool, market string, quantity float64, rate float64) (string, error) {
+	if err := c.fault(); err != nil {
+		return "", err
+	}
+
+	uid, err := c.ex.PlaceOrder(buy, market, quantity, rate)
+	if err == nil && c.roll(c.Conf.LostPlacements) {
+		c.mu.Lock()
+		c.Lost = append(c.Lost, uid)
+		c.mu.Unlock()
+
+		return "", errors.New("chaos: placement lost")
+	}
+
+	return uid, err
+}
+
+func (c *Chaos) GetOrders(market string) ([]string, error) {
+	if err := c.fault(); err != nil {
+		return nil, err
+	}
+
+	if c.roll(c.Conf.EmptyOrders) {
+		return nil, nil
+	}
+
+	return c.ex.GetOrders(market)
+}
+
+func (c *Chaos) CancelOrder(UID string) error {
+	if err := c.fault(); err != nil {
+		return err
+	}
+
+	return c.ex.CancelOrder(UID)
+}
+
+func (c *Chaos) GetTicker(market string) (Ticker, error) {
+	if err := c.fault(); err != nil {
+		return Ticker{}, err
+	}
+
+	c.mu.Lock()
+	stale, ok := c.tickers[market]
+	c.mu.Unlock()
+
+	if ok && c.roll(c.Conf.StaleTickers) {
+		return stale, nil
+	}
+
+	ticker, err := c.ex.GetTicker(market)
+	if err == nil {
+		c.mu.Lock()
+		c.tickers[market] = ticker
+		c.mu.Unlock()
+	}
+
+	return ticker, err
+}
+
+func (c *Chaos) GetBalance(asset string) (float64, error) {
+	if err := c.fault(); err != nil {
+		return 0, err
+	}
+
+	return c.ex.GetBalance(asset)
+}
@@ -0,0 +1,187 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// chaosCheck runs a book for m against a paper exchange behind a Chaos
+// exchange with conf, and checks the invariants of the book and the paper
+// balances after every tick.
+func chaosCheck(m Market, conf ChaosConfig, ticks int) error {
+	feed, paper := testPaper(m)
+	randomWalk(feed, m, conf.Seed)
+
+	chaos := NewChaos(paper, conf)
+	chaos.sleep = func(time.Duration) {}
+
+	b, err := newBook(m, chaos, "")
+	if err != nil {
+		return err
+	}
+	fund(paper, b)
+
+	for i := 0; i < ticks; i++ {
+		feed.next()
+		b.Tick()
+
+		if err := b.Check(); err != nil {
+			return fmt.Errorf("tick %d: %v", i, err)
+		}
+		if err := paper.Check(); err != nil {
+			return fmt.Errorf("tick %d: %v", i, err)
+		}
+
+	}
+
+	if b.Halted {
+		return fmt.Errorf("halted: %s", b.Reason)
+	}
+
+	return nil
+}
+
+func TestChaos(t *testing.T) {
+	quiet(t)
+
+	m := testMarket()
+	m.Assert = true
+
+	for _, conf := range []ChaosConfig{
+		{Latency: 0.5},
+		{Timeouts: 0.05, TimeoutAfter: 10},
+		{Errors: 0.1},
+		{EmptyOrders: 0.1},
+		{StaleTickers: 0.3},
+		{LostPlacements: 0.05},
+		{Latency: 0.5, Timeouts: 0.01, TimeoutAfter: 10, Errors: 0.05, EmptyOrders: 0.05, StaleTickers: 0.1, LostPlacements: 0.01},
+	} {
+		for seed := int64(1); seed <= 10; seed++ {
+			conf.Seed = seed
+			if err := chaosCheck(m, conf, 300); err != nil {
+				t.Errorf("%+v: %v", conf, err)
+				break
+			}
+		}
+	}
+}
+
+// TestEmptyOrders checks that a book skips a tick whose GetOrders reports
+// none of its resting orders, and believes the report once it persists.
+func TestEmptyOrders(t *testing.T) {
+	quiet(t)
+
+	m := testMarket()
+	m.Assert = true
+	_, paper := testPaper(m)
+
+	b := testBook(t, m, paper, "")
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+
+	middle, position, proceeds := b.middle(), b.Position, b.Proceeds
+	sides := make([]bool, len(b.Orders))
+	for i, order := range b.Orders {
+		sides[i] = order.Buy
+	}
+	fills := 0
+	b.Listen(func(Fill) { fills++ })
+
+	chaos := NewChaos(paper, ChaosConfig{EmptyOrders: 1})
+	b.Ex = chaos
+	for i := 1; i < emptyTicks; i++ {
+		if err := b.Tick(); err == nil {
+			t.Fatalf("tick %d: empty response was believed", i)
+		}
+	}
+
+	// The operator cancels everything
+	open, _ := paper.GetOrders(m.Market)
+	for _, uid := range open {
+		paper.CancelOrder(uid)
+	}
+	b.Ex = paper
+	for i := 0; i < emptyTicks; i++ {
+		b.Tick()
+	}
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := paper.GetOrders(m.Market); len(open) == 0 {
+		t.Fatal("the book never placed its orders again")
+	}
+	if err := b.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancelled orders are not fills
+	if fills > 0 {
+		t.Fatalf("%d cancelled orders reported as fills", fills)
+	}
+	if b.middle() != middle {
+		t.Fatalf("middle moved from %f to %f", middle, b.middle())
+	}
+	if b.Position != position || b.Proceeds != proceeds {
+		t.Fatalf("Position %f and Proceeds %f, were %f and %f", b.Position, b.Proceeds, position, proceeds)
+	}
+	for i, order := range b.Orders {
+		if order.Buy != sides[i] {
+			t.Fatalf("level %d at %f flipped", i, order.Rate)
+		}
+	}
+}
+
+// stalled holds GetOrders until release is closed.
+type stalled struct {
+	Exchange
+	entered chan bool
+	release chan bool
+}
+
+func (s *stalled) GetOrders(market string) ([]string, error) {
+	s.entered <- true
+	<-s.release
+	return s.Exchange.GetOrders(market)
+}
+
+func TestOverlappingTicks(t *testing.T) {
+	quiet(t)
+
+	m := testMarket()
+	_, paper := testPaper(m)
+
+	b := testBook(t, m, paper, "")
+	ex := &stalled{paper, make(chan bool), make(chan bool)}
+	b.Ex = ex
+
+	done := make(chan error)
+	go func() { done <- b.Tick() }()
+	<-ex.entered
+
+	if err := b.Tick(); err == nil {
+		t.Fatal("a tick ran while the previous one was still running")
+	}
+
+	close(ex.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
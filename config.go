@@ -16,20 +16,185 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>. */
 
 package main
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type Config struct {
 	Exchange string
 	Apikey   string
 	Secret   string
+	Record   string
+	Paper    PaperConfig
+	Chaos    *ChaosConfig
+	Allocate bool
 	Markets  []Market
 }
 
 type Market struct {
-	Market   string
-	High     float64
-	Low      float64
-	Start    float64
+	Market      string
+	High        float64
+	Low         float64
+	Start       float64
+	Interval    float64
+	Quantity    float64
+	Spacing     string
+	Levels      []float64
+	Sizing      string
+	SizeFactor  float64
+	Exit        string
+	Skew        string
+	Target      float64
+	MaxSkew     float64
+	SkewLevels  int
+	MinInterval float64
+	MaxInterval float64
+	VolWindow   int
+	VolEvery    int
+	VolMultiple float64
+	Gamma       float64
+	Kappa       float64
+	Horizon     int
+	Quotes      int
+	Tolerance   float64
+	Window      int
+	Hedge       *HedgeConfig
+	Weight      float64
+	Profiles    map[string]Profile
+	Schedule    []Rule
+	Reference   *ReferenceConfig
+	Risk        *RiskConfig
+	Breaker     *BreakerConfig
+	MaxAsset    float64
+	MaxCurrency float64
+	Strategy    string
+	Assert      bool
+
+	autoStart  bool
+	highOffset *float64
+	lowOffset  *float64
+}
+
+// UnmarshalJSON reads a market from the config. Start may be "auto" to start
+// at the market's price when its book is first built, and High and Low may be
+// signed percentages of Start such as "+20%" and "-20%".
+func (m *Market) UnmarshalJSON(data []byte) error {
+	type market Market
+	var raw struct {
+		market
+		High  json.RawMessage
+		Low   json.RawMessage
+		Start json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = Market(raw.market)
+
+	var err error
+	if m.Start, m.autoStart, err = parseStart(raw.Start); err != nil {
+		return fmt.Errorf("%s: Start: %v", m.Market, err)
+	}
+	if m.High, m.highOffset, err = parseBound(raw.High); err != nil {
+		return fmt.Errorf("%s: High: %v", m.Market, err)
+	}
+	if m.Low, m.lowOffset, err = parseBound(raw.Low); err != nil {
+		return fmt.Errorf("%s: Low: %v", m.Market, err)
+	}
+
+	return nil
+}
+
+func parseStart(raw json.RawMessage) (float64, bool, error) {
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, false, err
+		}
+		if s != "auto" {
+			return 0, false, fmt.Errorf("expected a price or \"auto\", got %q", s)
+		}
+		return 0, true, nil
+	}
+
+	var price float64
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &price); err != nil {
+			return 0, false, err
+		}
+	}
+	return price, false, nil
+}
+
+func parseBound(raw json.RawMessage) (float64, *float64, error) {
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, nil, err
+		}
+		if !strings.HasSuffix(s, "%") {
+			return 0, nil, fmt.Errorf("expected a price or a percentage, got %q", s)
+		}
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("expected a price or a percentage, got %q", s)
+		}
+		if pct <= -100 {
+			return 0, nil, fmt.Errorf("%s would put the bound at or below zero", s)
+		}
+		offset := pct / 100
+		return 0, &offset, nil
+	}
+
+	var price float64
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &price); err != nil {
+			return 0, nil, err
+		}
+	}
+	return price, nil, nil
+}
+
+// relative reports whether the range of m depends on the market's price.
+func (m Market) relative() bool {
+	return m.autoStart || m.highOffset != nil || m.lowOffset != nil
+}
+
+// resolve sets an automatic or missing Start to price and turns a High and Low given as
+// offsets into prices around Start.
+func (m *Market) resolve(price float64) {
+	if m.autoStart || m.Start == 0 {
+		m.Start = price
+		m.autoStart = false
+	}
+	if m.highOffset != nil {
+		m.High = m.Start * (1 + *m.highOffset)
+		m.highOffset = nil
+	}
+	if m.lowOffset != nil {
+		m.Low = m.Start * (1 + *m.lowOffset)
+		m.lowOffset = nil
+	}
+}
+
+// Profile is a named set of parameters a market switches to on its
+// schedule. Parameters left at zero keep the market's own.
+type Profile struct {
 	Interval float64
 	Quantity float64
+	Window   int
+	Paused   bool
+}
+
+// Rule switches a market to the named profile while the time matches the
+// cron expression.
+type Rule struct {
+	Cron    string
+	Profile string
 }
 
 func Load() ([]*Book, error) {
@@ -39,18 +204,91 @@ func Load() ([]*Book, error) {
 		return nil, err
 	}
 
-	var exchange Exchange
-	switch conf.Exchange {
-	case "poloniex":
-		exchange = PoloniexConnect(conf.Apikey, []byte(conf.Secret))
-	case "vertpig":
-		exchange = VertpigConnect(conf.Apikey, []byte(conf.Secret))
+	exchange, err := connect(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Faults injected into a real exchange would place real orders the
+	// books do not know about
+	if conf.Chaos != nil {
+		if conf.Exchange != "paper" {
+			return nil, errors.New("Chaos can only be used with the paper exchange")
+		}
+		exchange = NewChaos(exchange, *conf.Chaos)
+	}
+
+	if conf.Record != "" {
+		exchange, err = NewRecorder(exchange, conf.Record)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var alloc *Allocator
+	if conf.Allocate {
+		alloc = NewAllocator(exchange)
 	}
 
 	var ret []*Book
 	for _, m := range conf.Markets {
-		ret = append(ret, NewBook(m.Market, m.High, m.Low, m.Start, m.Interval, m.Quantity, exchange))
+		b, err := NewBook(m, exchange)
+		if err != nil {
+			return nil, err
+		}
+
+		if alloc != nil {
+			alloc.Add(b, m.Weight)
+		}
+
+		if m.Hedge != nil {
+			hedge, err := connectHedge(conf, m)
+			if err != nil {
+				return nil, err
+			}
+
+			h, err := NewHedger(*m.Hedge, m.Market, hedge, "./"+hedge.Name()+"hedge"+m.Market)
+			if err != nil {
+				return nil, err
+			}
+			b.Listen(h.OnFill)
+		}
+
+		ret = append(ret, b)
 	}
 
 	return ret, nil
 }
+
+// connect returns the exchange conf trades on, the paper exchange when it
+// paper trades.
+func connect(conf Config) (Exchange, error) {
+	if conf.Exchange != "paper" {
+		return Connect(conf.Exchange, conf.Apikey, conf.Secret)
+	}
+
+	var feed Exchange
+	var err error
+	if conf.Paper.Cassette != "" {
+		feed, err = NewReplayer(conf.Paper.Cassette)
+	} else {
+		feed, err = Connect(conf.Paper.Feed, conf.Apikey, conf.Secret)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPaper(feed, conf.Paper.Fee, conf.Paper.Balances, "./paperstate"), nil
+}
+
+// Connect returns the adapter for the named exchange.
+func Connect(name string, apikey string, secret string) (Exchange, error) {
+	switch name {
+	case "poloniex":
+		return PoloniexConnect(apikey, []byte(secret)), nil
+	case "vertpig":
+		return VertpigConnect(apikey, []byte(secret)), nil
+	}
+
+	return nil, fmt.Errorf("Unknown exchange: %s", name)
+}
@@ -60,6 +60,15 @@ type Exchange interface {
 	GetBalance(asset string) (float64, error)
 }
 
+// Taker is implemented by exchanges that can trade straight away against the
+// orders resting in a market.
+type Taker interface {
+	// Take buys or sells up to quantity of the asset at the best rates in the
+	// market and returns the quantity that traded and its average rate, or an
+	// error. Whatever does not trade straight away is cancelled.
+	Take(buy bool, market string, quantity float64) (float64, float64, error)
+}
+
 func hmacSign(message []byte, key []byte) string {
 	h := hmac.New(sha512.New, key)
 	h.Write(message)
@@ -0,0 +1,507 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// Grid is the constant interval strategy. Levels are laid out between High
+// and Low with sells above the middle and buys below it. When orders fill the
+// middle moves to the filled level nearest the unfilled side and every filled
+// level is placed again on its side of the new middle.
+type Grid struct {
+	ticker Ticker
+	ticks  int
+}
+
+// rates returns the rates of the levels of b from highest to lowest. With
+// arithmetic spacing the levels are Interval*Start apart starting from High,
+// with geometric spacing each level is Interval, as a fraction, above the one
+// below it with a level at Start, and with levels spacing they are the
+// book's Levels.
+func (g *Grid) rates(b *Book) ([]float64, error) {
+	var rates []float64
+
+	switch b.Spacing {
+	case "", "arithmetic":
+		if b.Interval <= 0 || b.Start <= 0 {
+			return nil, errors.New("Arithmetic spacing needs a positive Interval and Start")
+		}
+		for i := b.High; i >= b.Low; i -= b.Interval * b.Start {
+			rates = append(rates, i)
+		}
+	case "geometric":
+		if b.Interval <= 0 || b.Start <= 0 || b.Low <= 0 {
+			return nil, errors.New("Geometric spacing needs a positive Interval, Start and Low")
+		}
+		// Allow for rounding when High or Low sit on a level, as they do
+		// after the grid trails the price.
+		top := math.Floor(math.Log(b.High/b.Start)/math.Log1p(b.Interval) + 1e-9)
+		bottom := math.Ceil(math.Log(b.Low/b.Start)/math.Log1p(b.Interval) - 1e-9)
+		for k := top; k >= bottom; k-- {
+			rates = append(rates, b.Start*math.Pow(1+b.Interval, k))
+		}
+	case "levels":
+		rates = append(rates, b.Levels...)
+		sort.Sort(sort.Reverse(sort.Float64Slice(rates)))
+	default:
+		return nil, fmt.Errorf("Unknown spacing: %s", b.Spacing)
+	}
+
+	if len(rates) == 0 {
+		return nil, errors.New("The book has no levels")
+	}
+
+	return rates, nil
+}
+
+// exit returns an error if the book's exit policy cannot be used.
+func (g *Grid) exit(b *Book) error {
+	switch b.Exit {
+	case "", "stop":
+	case "trail", "rebuild":
+		if b.Spacing == "levels" {
+			return fmt.Errorf("Levels spacing cannot %s when the price leaves the range", b.Exit)
+		}
+	default:
+		return fmt.Errorf("Unknown exit policy: %s", b.Exit)
+	}
+
+	return nil
+}
+
+func (g *Grid) Build(b *Book, middle float64) ([]Order, error) {
+	if err := g.exit(b); err != nil {
+		return nil, err
+	}
+
+	rates, err := g.rates(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []Order
+	for _, rate := range rates {
+		orders = append(orders, Order{Rate: rate})
+	}
+
+	setMiddle(orders, middle)
+
+	if err := g.size(b, orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// size sets the quantity of every level. Quantity is spent in currency on
+// each level with quote sizing and bought or sold in asset on each level
+// with base sizing. Center and edges sizing spend Quantity in currency on the
+// levels furthest from and nearest to the middle respectively, growing
+// linearly to SizeFactor times as much at the other end. Balance sizing
+// commits the fraction Quantity of the current balance of each side, split
+// evenly over the levels on that side.
+func (g *Grid) size(b *Book, orders []Order) error {
+	middle := 0
+	for i, order := range orders {
+		if order.Middle {
+			middle = i
+		}
+	}
+
+	factor := b.SizeFactor
+	if factor == 0 {
+		factor = 2
+	}
+
+	// distance is how far level i is from the middle as a fraction of the
+	// distance to the end of the ladder on its side.
+	distance := func(i int) float64 {
+		if i < middle {
+			return float64(middle-i) / float64(middle)
+		}
+		if i > middle {
+			return float64(i-middle) / float64(len(orders)-1-middle)
+		}
+		return 0
+	}
+
+	var assetBal, currencyBal float64
+	if b.Sizing == "balance" {
+		asset, currency := splitMarket(b.Market)
+
+		var err error
+		assetBal, err = b.Ex.GetBalance(asset)
+		if err != nil {
+			return err
+		}
+		currencyBal, err = b.Ex.GetBalance(currency)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, order := range orders {
+		rate := order.Rate
+		switch b.Sizing {
+		case "", "quote":
+			orders[i].Quantity = b.Quantity / rate
+		case "base":
+			orders[i].Quantity = b.Quantity
+		case "center":
+			orders[i].Quantity = b.Quantity / rate * (factor - (factor-1)*distance(i))
+		case "edges":
+			orders[i].Quantity = b.Quantity / rate * (1 + (factor-1)*distance(i))
+		case "balance":
+			buy, sell := math.Inf(1), math.Inf(1)
+			if buys := len(orders) - 1 - middle; buys > 0 {
+				buy = currencyBal * b.Quantity / float64(buys) / rate
+			}
+			if middle > 0 {
+				sell = assetBal * b.Quantity / float64(middle)
+			}
+
+			switch {
+			case order.Middle:
+				// The middle joins whichever side the price leaves it on, so
+				// it is sized to be affordable on both
+				orders[i].Quantity = math.Min(buy, sell)
+			case order.Buy:
+				orders[i].Quantity = buy
+			default:
+				orders[i].Quantity = sell
+			}
+		default:
+			return fmt.Errorf("Unknown sizing: %s", b.Sizing)
+		}
+	}
+
+	return nil
+}
+
+func (g *Grid) OnFills(b *Book, filled []int) error {
+	if len(filled) == 0 {
+		return nil
+	}
+
+	// Only orders that filled this tick move the middle. Levels still
+	// waiting to be placed, because their placement failed, are Filled as
+	// well but have not traded.
+	fresh := map[int]bool{}
+	for _, i := range filled {
+		fresh[i] = true
+	}
+
+	// Set new midpoint
+	orig := 0
+	for i, order := range b.Orders {
+		if order.Middle {
+			orig = i
+			b.Orders[i].Middle = false
+			break
+		}
+	}
+
+	log.Printf("Old middle: %v", orig)
+
+	middle := orig
+	for i, order := range b.Orders {
+		run := false
+		if fresh[i] && i < orig {
+			log.Printf("Setting middle at %d, price: %f because it's the first filled order we saw", i, order.Rate)
+			run = true
+		} else if i+1 < len(b.Orders) {
+			if !fresh[i+1] && fresh[i] {
+				log.Printf("Setting middle at %d, price: %f because the next order is unfilled", i, order.Rate)
+
+				run = true
+
+				for j := i + 1; j < len(b.Orders); j++ {
+					if fresh[j] {
+						log.Printf("Actually scratch that, there is a later filled order at %d", j)
+						run = false
+						break
+					}
+				}
+			}
+		}
+
+		if run {
+			middle = i
+			break
+		}
+	}
+
+	log.Printf("New middle: %d", middle)
+
+	b.Orders[middle].Middle = true
+
+	// The old middle has no order resting, place it on its new side
+	if middle != orig {
+		b.Orders[orig].Filled = true
+	}
+
+	for i, order := range b.Orders {
+		if order.Filled {
+			if i <= middle {
+				b.Orders[i].Buy = false
+			} else {
+				b.Orders[i].Buy = true
+			}
+			b.Orders[i].Flipped = false
+		}
+	}
+
+	return nil
+}
+
+func (g *Grid) OnTicker(b *Book, ticker Ticker) error {
+	if err := g.exit(b); err != nil {
+		return err
+	}
+
+	g.ticker = ticker
+
+	// A book that has never placed an order starts at the reference price
+	if b.reference != nil && b.FirstRun {
+		placed := false
+		for _, order := range b.Orders {
+			placed = placed || order.UID != ""
+		}
+		if !placed {
+			if err := b.migrate(b.price(ticker)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := g.leave(b); err != nil {
+		return err
+	}
+
+	return g.adapt(b)
+}
+
+// leave moves the ladder when the price leaves the range between High and
+// Low. With the trail exit policy the range is shifted by whole levels until
+// the price is back at its edge, leaving the book on one side of it, and with
+// the rebuild policy the range is scaled to be centred on the price as it was
+// on Start. The stop policy leaves the book as it is.
+func (g *Grid) leave(b *Book) error {
+	price := b.price(g.ticker)
+	if price <= 0 || (price <= b.High && price >= b.Low) {
+		return nil
+	}
+
+	switch b.Exit {
+	case "trail":
+		levels := 0
+		switch spacing(b.Spacing) {
+		case "arithmetic":
+			step := b.Interval * b.Start
+			if price > b.High {
+				levels = int(math.Floor((price - b.High) / step))
+			} else {
+				levels = -int(math.Floor((b.Low - price) / step))
+			}
+			b.High += float64(levels) * step
+			b.Low += float64(levels) * step
+		case "geometric":
+			if price > b.High {
+				levels = int(math.Floor(math.Log(price/b.High) / math.Log1p(b.Interval)))
+			} else {
+				levels = -int(math.Floor(math.Log(b.Low/price) / math.Log1p(b.Interval)))
+			}
+			b.High *= math.Pow(1+b.Interval, float64(levels))
+			b.Low *= math.Pow(1+b.Interval, float64(levels))
+		}
+		if levels == 0 {
+			return nil
+		}
+
+		log.Printf("%s: price %f left the range, trailing the grid by %d levels", b.Market, price, levels)
+
+		b.Shift += levels
+
+		// The price is still beyond the edge of the range, so the book is
+		// left holding one side with the middle at that edge.
+		return b.migrate(price)
+	case "rebuild":
+		log.Printf("%s: price %f left the range, rebuilding the grid around it", b.Market, price)
+
+		scale := price / b.Start
+		b.High *= scale
+		b.Low *= scale
+		b.Start = price
+
+		return b.migrate(price)
+	}
+
+	return nil
+}
+
+// adapt rebuilds the ladder every VolEvery ticks with an Interval of
+// VolMultiple times the volatility of the price over the book's history of
+// VolWindow prices, kept between MinInterval and MaxInterval. The ladder is
+// left alone unless the Interval changes by a tenth or more.
+func (g *Grid) adapt(b *Book) error {
+	if b.conf.MaxInterval == 0 {
+		return nil
+	}
+	if b.Spacing == "levels" {
+		return errors.New("Levels spacing cannot adapt its interval")
+	}
+	if b.conf.VolWindow < 3 || b.conf.MinInterval > b.conf.MaxInterval {
+		return errors.New("Adaptive spacing needs a VolWindow of at least 3 and a MinInterval below MaxInterval")
+	}
+
+	every := b.conf.VolEvery
+	if every == 0 {
+		every = b.conf.VolWindow
+	}
+
+	g.ticks++
+	if g.ticks < every || len(b.recent()) < b.conf.VolWindow {
+		return nil
+	}
+	g.ticks = 0
+
+	multiple := b.conf.VolMultiple
+	if multiple == 0 {
+		multiple = 1
+	}
+
+	vol := b.volatility() * math.Sqrt(float64(len(b.recent())-1))
+	interval := math.Min(math.Max(multiple*vol, b.conf.MinInterval), b.conf.MaxInterval)
+	if math.Abs(interval-b.Interval) < 0.1*b.Interval {
+		return nil
+	}
+
+	log.Printf("%s: volatility %f, changing interval from %f to %f", b.Market, vol, b.Interval, interval)
+
+	b.Interval = interval
+
+	return b.migrate(b.middle())
+}
+
+// Orders returns the levels of the book, with those nearest the middle
+// skewed by the inventory when the market has a Skew mode.
+func (g *Grid) Orders(b *Book) ([]Order, error) {
+	orders := append([]Order(nil), b.Orders...)
+
+	for i, order := range orders {
+		if order.Level != 0 {
+			orders[i].Rate, orders[i].Level = order.Level, 0
+		}
+		if order.Size != 0 {
+			orders[i].Quantity, orders[i].Size = order.Size, 0
+		}
+	}
+
+	if b.conf.Skew == "" {
+		return orders, nil
+	}
+
+	skew, err := g.skew(b)
+	if err != nil {
+		return nil, err
+	}
+	if skew == 0 {
+		return orders, nil
+	}
+
+	levels := b.conf.SkewLevels
+	if levels == 0 {
+		levels = 1
+	}
+
+	middle := 0
+	for i, order := range orders {
+		if order.Middle {
+			middle = i
+		}
+	}
+
+	for i, order := range orders {
+		if order.Middle || i < middle-levels || i > middle+levels {
+			continue
+		}
+
+		switch b.conf.Skew {
+		case "price":
+			// Holding too much asset lowers both sides so we sell sooner and
+			// buy later, but never so far that the order would trade.
+			rate := order.Rate * (1 - skew*b.conf.MaxSkew)
+			if (order.Buy && rate >= g.ticker.Ask) || (!order.Buy && rate <= g.ticker.Bid) {
+				continue
+			}
+			orders[i].Rate, orders[i].Level = rate, order.Rate
+		case "size":
+			scale := 1 + skew*b.conf.MaxSkew
+			if order.Buy {
+				scale = 1 - skew*b.conf.MaxSkew
+			}
+			orders[i].Quantity, orders[i].Size = order.Quantity*scale, order.Quantity
+		}
+	}
+
+	return orders, nil
+}
+
+// skew returns how far the share of the book's holdings, including the orders
+// resting on the exchange, that is held in the asset is from the market's
+// Target share, from -1 when it holds only currency to 1 when it holds only
+// asset. It is rounded to tenths so the orders are only moved when the
+// inventory changes noticeably.
+func (g *Grid) skew(b *Book) (float64, error) {
+	switch b.conf.Skew {
+	case "price":
+	case "size":
+		if b.conf.MaxSkew > 1 {
+			return 0, errors.New("Size skew needs a MaxSkew of at most 1")
+		}
+	default:
+		return 0, fmt.Errorf("Unknown skew: %s", b.conf.Skew)
+	}
+	if b.conf.Target <= 0 || b.conf.Target >= 1 {
+		return 0, errors.New("Skew needs a Target between 0 and 1")
+	}
+
+	price := b.price(g.ticker)
+	if price <= 0 {
+		return 0, nil
+	}
+
+	assetBal, currencyBal, err := b.holdings()
+	if err != nil {
+		return 0, err
+	}
+
+	if assetBal*price+currencyBal <= 0 {
+		return 0, nil
+	}
+
+	share := assetBal * price / (assetBal*price + currencyBal)
+	skew := (share - b.conf.Target) / math.Max(b.conf.Target, 1-b.conf.Target)
+
+	return math.Round(skew*10) / 10, nil
+}
@@ -0,0 +1,179 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+)
+
+// HedgeConfig sets the second exchange that takes the opposite side of the
+// fills of a market.
+type HedgeConfig struct {
+	Exchange string
+	Apikey   string
+	Secret   string
+
+	// Feed prices the hedge exchange when it is paper.
+	Feed string
+
+	// Market is the market to hedge in, the book's market by default.
+	Market string
+
+	// MinQuantity is the least amount of asset to trade at once. Smaller
+	// exposures are carried until more fills add to them.
+	MinQuantity float64
+}
+
+// Hedger trades the fills of a book back the other way on a second
+// exchange, keeping the book's exposure to the asset flat.
+type Hedger struct {
+	Market string
+
+	// Unhedged is the asset bought by the book and not yet sold on the hedge
+	// exchange, negative when sold and not yet bought back, and Basis is what
+	// the book paid for it in currency, negative when it received currency.
+	Unhedged float64
+	Basis    float64
+
+	// Hedged is the total asset traded on the hedge exchange and Slippage the
+	// currency lost by trading it at worse rates than the book filled at.
+	Hedged   float64
+	Slippage float64
+
+	conf HedgeConfig
+	ex   Taker
+	path string
+	mu   sync.Mutex
+}
+
+// connectHedge returns the exchange the market m hedges on. A paper hedge
+// exchange is priced by the Feed of the hedge config and starts with the
+// balances of the paper config.
+func connectHedge(conf Config, m Market) (Exchange, error) {
+	h := m.Hedge
+	if h.Exchange != "paper" {
+		return Connect(h.Exchange, h.Apikey, h.Secret)
+	}
+
+	feed, err := Connect(h.Feed, h.Apikey, h.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPaper(feed, conf.Paper.Fee, conf.Paper.Balances, "./paperhedge"+m.Market), nil
+}
+
+// NewHedger returns a hedger for the book trading market on exchange. The
+// hedger's state is loaded from and persisted to path unless it is empty,
+// and any exposure left unhedged by the last run is hedged straight away.
+func NewHedger(conf HedgeConfig, market string, exchange Exchange, path string) (*Hedger, error) {
+	taker, ok := exchange.(Taker)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot take liquidity to hedge", exchange.Name())
+	}
+
+	if conf.Market == "" {
+		conf.Market = market
+	}
+
+	h := &Hedger{
+		Market: conf.Market,
+		conf:   conf,
+		ex:     taker,
+		path:   path,
+	}
+
+	if path != "" {
+		if err := LoadStruct(path, h); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	if h.Unhedged != 0 {
+		log.Printf("%s: %f left unhedged by the last run", h.Market, h.Unhedged)
+
+		h.mu.Lock()
+		h.hedge()
+		h.mu.Unlock()
+	}
+
+	return h, nil
+}
+
+// OnFill adds a fill of the book to the exposure and hedges it.
+func (h *Hedger) OnFill(f Fill) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if f.Buy {
+		h.Unhedged += f.Quantity
+		h.Basis += f.Quantity * f.Rate
+	} else {
+		h.Unhedged -= f.Quantity
+		h.Basis -= f.Quantity * f.Rate
+	}
+
+	h.hedge()
+}
+
+// hedge trades the exposure away on the hedge exchange once it is at least
+// MinQuantity. If the trade fails the exposure is kept for the next fill.
+// The caller must hold h.mu.
+func (h *Hedger) hedge() {
+	defer h.save()
+
+	quantity := math.Abs(h.Unhedged)
+	if quantity == 0 || quantity < h.conf.MinQuantity {
+		return
+	}
+
+	taken, rate, err := h.ex.Take(h.Unhedged < 0, h.Market, quantity)
+	if err != nil {
+		log.Printf("%s: hedge failed, %f left unhedged: %v", h.Market, h.Unhedged, err)
+		return
+	}
+	if taken == 0 {
+		log.Printf("%s: nothing traded to hedge, %f left unhedged", h.Market, h.Unhedged)
+		return
+	}
+
+	// A partial trade hedges its share of the exposure and what was paid for
+	// it, the rest is kept for the next fill
+	share := math.Min(taken/quantity, 1)
+	hedged, basis := h.Unhedged*share, h.Basis*share
+	slippage := basis - hedged*rate
+
+	log.Printf("%s: hedged %f at %f, slippage: %f", h.Market, hedged, rate, slippage)
+
+	h.Hedged += math.Abs(hedged)
+	h.Slippage += slippage
+	h.Unhedged -= hedged
+	h.Basis -= basis
+}
+
+func (h *Hedger) save() {
+	if h.path == "" {
+		return
+	}
+
+	if err := SaveStruct(h.path, h); err != nil {
+		log.Printf("%v", err)
+	}
+}
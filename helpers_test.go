@@ -0,0 +1,116 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// walk is a price feed that follows a random walk with occasional jumps
+// across many levels.
+type walk struct {
+	price float64
+	step  float64
+	jump  float64
+	rnd   *rand.Rand
+}
+
+func (w *walk) next() {
+	move := w.step * w.rnd.NormFloat64()
+	if w.rnd.Float64() < 0.05 {
+		move = w.jump * w.rnd.NormFloat64()
+	}
+	w.price *= math.Exp(move)
+}
+
+func (w *walk) Name() string {
+	return "walk"
+}
+
+func (w *walk) PlaceOrder(buy bool, market string, quantity float64, rate float64) (string, error) {
+	return "", errors.New("walk is read only")
+}
+
+func (w *walk) GetOrders(market string) ([]string, error) {
+	return nil, errors.New("walk is read only")
+}
+
+func (w *walk) CancelOrder(UID string) error {
+	return errors.New("walk is read only")
+}
+
+func (w *walk) GetTicker(market string) (Ticker, error) {
+	return Ticker{w.price * 0.999, w.price * 1.001, w.price}, nil
+}
+
+func (w *walk) GetBalance(asset string) (float64, error) {
+	return 0, errors.New("walk is read only")
+}
+
+// quiet discards the log output until the test ends.
+func quiet(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// testMarket returns the VTCBTC market of the example config.
+func testMarket() Market {
+	return Market{Market: "VTCBTC", High: 0.0041, Low: 0.00015, Start: 0.0024128, Interval: 0.01, Quantity: 0.004}
+}
+
+// testPaper returns a paper exchange holding 1000 of the asset of m and 10
+// of its currency, priced by a walk that stays at the Start of m until it is
+// moved.
+func testPaper(m Market) (*walk, *Paper) {
+	feed := &walk{price: m.Start}
+	a, c := splitMarket(m.Market)
+	return feed, NewPaper(feed, 0.0025, map[string]float64{a: 1000, c: 10}, "")
+}
+
+// randomWalk makes feed follow a random walk seeded by seed, with steps of
+// the Interval of m and jumps of five.
+func randomWalk(feed *walk, m Market, seed int64) {
+	feed.step, feed.jump = m.Interval, 5*m.Interval
+	feed.rnd = rand.New(rand.NewSource(seed))
+}
+
+// fund gives paper twice the capital the levels of b need, so that it is
+// the book and not the balances that runs out first.
+func fund(paper *Paper, b *Book) {
+	currency, asset := b.Capital()
+	a, c := splitMarket(b.Market)
+	paper.Balances[a], paper.Balances[c] = 2*asset, 2*currency
+	paper.Initial[a], paper.Initial[c] = 2*asset, 2*currency
+}
+
+// testBook returns the book for m on ex, persisted to path unless it is
+// empty.
+func testBook(t *testing.T, m Market, ex Exchange, path string) *Book {
+	t.Helper()
+
+	b, err := newBook(m, ex, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
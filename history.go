@@ -0,0 +1,73 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import "math"
+
+// history returns how many ticker prices the book keeps, enough for both its
+// volatility window and its circuit breaker.
+func (b *Book) history() int {
+	n := b.conf.VolWindow
+	if b.conf.Breaker != nil && b.conf.Breaker.window() > n {
+		n = b.conf.Breaker.window()
+	}
+	return n
+}
+
+// recent returns the last VolWindow prices of the book's history.
+func (b *Book) recent() []float64 {
+	if n := b.conf.VolWindow; len(b.Prices) > n {
+		return b.Prices[len(b.Prices)-n:]
+	}
+	return b.Prices
+}
+
+// record adds the middle of the bid and ask of ticker to the book's price
+// history, dropping the oldest prices beyond its length.
+func (b *Book) record(ticker Ticker) {
+	price := (ticker.Bid + ticker.Ask) / 2
+	if price <= 0 {
+		return
+	}
+
+	b.Prices = append(b.Prices, price)
+	if n := b.history(); len(b.Prices) > n {
+		b.Prices = append([]float64(nil), b.Prices[len(b.Prices)-n:]...)
+	}
+}
+
+// volatility returns the standard deviation of the log returns between
+// consecutive prices in the book's volatility window, or zero if it has too
+// few.
+func (b *Book) volatility() float64 {
+	prices := b.recent()
+	if len(prices) < 3 {
+		return 0
+	}
+
+	var sum, squares float64
+	for i := 1; i < len(prices); i++ {
+		r := math.Log(prices[i] / prices[i-1])
+		sum += r
+		squares += r * r
+	}
+
+	n := float64(len(prices) - 1)
+	mean := sum / n
+
+	return math.Sqrt(math.Max(squares/n-mean*mean, 0))
+}
@@ -0,0 +1,116 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Check returns an error describing the first broken invariant of the book:
+// there is exactly one middle, every level above it is a sell and every level
+// below it a buy, every level but the middle has a quantity to place and no
+// two levels share a UID. Levels that were flipped to
+// the other side because their placement would have traded are exempt from
+// the side check until they next fill.
+func (b *Book) Check() error {
+	middle := -1
+	for i, order := range b.Orders {
+		if order.Middle {
+			if middle >= 0 {
+				return fmt.Errorf("levels %d and %d are both the middle", middle, i)
+			}
+			middle = i
+		}
+	}
+	if middle < 0 {
+		return errors.New("there is no middle")
+	}
+
+	uids := map[string]int{}
+	for i, order := range b.Orders {
+		if order.UID != "" {
+			if j, ok := uids[order.UID]; ok {
+				return fmt.Errorf("levels %d and %d share UID %s", j, i, order.UID)
+			}
+			uids[order.UID] = i
+		}
+
+		if !order.Middle && !(order.Quantity > 0) {
+			return fmt.Errorf("level %d at %f has quantity %f", i, order.Rate, order.Quantity)
+		}
+
+		if order.Middle || order.Flipped {
+			continue
+		}
+		if i < middle && order.Buy {
+			return fmt.Errorf("level %d at %f is a buy above the middle at %d", i, order.Rate, middle)
+		}
+		if i > middle && !order.Buy {
+			return fmt.Errorf("level %d at %f is a sell below the middle at %d", i, order.Rate, middle)
+		}
+	}
+
+	return nil
+}
+
+// Check returns an error if the balances of the paper exchange, including
+// those reserved by resting orders, differ from its initial balances moved
+// by its fills.
+func (p *Paper) Check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	want := map[string]float64{}
+	for asset, bal := range p.Initial {
+		want[asset] = bal
+	}
+	for _, f := range p.Fills {
+		asset, currency := splitMarket(f.Market)
+		if f.Buy {
+			want[asset] += f.Quantity - f.Fee
+			want[currency] -= f.Quantity * f.Rate
+		} else {
+			want[asset] -= f.Quantity
+			want[currency] += f.Quantity*f.Rate - f.Fee
+		}
+	}
+
+	have := map[string]float64{}
+	for asset, bal := range p.Balances {
+		have[asset] += bal
+	}
+	for _, o := range p.Orders {
+		asset, currency := splitMarket(o.Market)
+		if o.Buy {
+			have[currency] += o.Quantity * o.Rate
+		} else {
+			have[asset] += o.Quantity
+		}
+	}
+
+	for _, m := range []map[string]float64{want, have} {
+		for asset := range m {
+			if math.Abs(want[asset]-have[asset]) > 1e-9*math.Max(1, math.Abs(want[asset])) {
+				return fmt.Errorf("%s balance is %f, expected %f", asset, have[asset], want[asset])
+			}
+		}
+	}
+
+	return nil
+}
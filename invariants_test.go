@@ -0,0 +1,73 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// propertyCheck runs a book for m against a paper exchange whose price
+// follows a random walk seeded by seed, so that the orders fill in a random
+// sequence, and checks the invariants of the book and the paper exchange's
+// balances after every tick.
+func propertyCheck(m Market, seed int64, ticks int) error {
+	// Balance sizing sizes the levels from what the exchange holds
+	feed, ex := testPaper(m)
+	randomWalk(feed, m, seed)
+	b, err := newBook(m, ex, "")
+	if err != nil {
+		return err
+	}
+	fund(ex, b)
+
+	for i := 0; i < ticks; i++ {
+		feed.next()
+		b.Tick()
+
+		if err := b.Check(); err != nil {
+			return fmt.Errorf("seed %d, tick %d: %v", seed, i, err)
+		}
+		if err := ex.Check(); err != nil {
+			return fmt.Errorf("seed %d, tick %d: %v", seed, i, err)
+		}
+	}
+
+	return nil
+}
+
+func TestInvariants(t *testing.T) {
+	quiet(t)
+
+	markets := []Market{
+		testMarket(),
+		{Market: "VTCLTC", High: 0.04, Low: 0.007, Start: 0.0156372, Interval: 0.005, Quantity: 0.007},
+		{Market: "VTCBTC", High: 0.004, Low: 0.001, Start: 0.0024, Interval: 0.01, Spacing: "geometric", Sizing: "edges", Quantity: 10},
+		{Market: "VTCBTC", High: 0.004, Low: 0.001, Start: 0.0024, Interval: 0.01, Spacing: "geometric", Sizing: "balance", Quantity: 0.5},
+		{Market: "VTCBTC", High: 0.004, Low: 0.001, Start: 0.0024, Interval: 0.01, Spacing: "geometric", Window: 5, Quantity: 0.004},
+		{Market: "VTCBTC", High: 0.004, Low: 0.001, Start: 0.0024, Interval: 0.01, Spacing: "geometric", Strategy: "pairs", Quantity: 0.004},
+	}
+
+	for _, m := range markets {
+		for seed := int64(1); seed <= 20; seed++ {
+			if err := propertyCheck(m, seed, 200); err != nil {
+				t.Errorf("%s %s %s: %v", m.Market, spacing(m.Spacing), m.Strategy, err)
+				break
+			}
+		}
+	}
+}
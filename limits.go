@@ -0,0 +1,151 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// count adds the orders at filled to the book's Position, the asset its fills
+// have brought in, and Proceeds, the currency they have. Both are negative
+// when the fills have taken more away.
+func (b *Book) count(filled []int) {
+	for _, i := range filled {
+		order := b.Orders[i]
+		if order.Buy {
+			b.Position += order.Quantity
+			b.Proceeds -= order.Quantity * order.Rate
+		} else {
+			b.Position -= order.Quantity
+			b.Proceeds += order.Quantity * order.Rate
+		}
+	}
+}
+
+// limit holds back virtually the levels of orders waiting to be placed that
+// could take the book past its inventory limits were every resting order on
+// their side to fill: buys that could take its Position past MaxAsset and
+// sells that could take its Proceeds past MaxCurrency. The levels nearest the
+// middle are placed first and orders already resting are left alone.
+func (b *Book) limit(orders []Order) {
+	if b.conf.MaxAsset <= 0 && b.conf.MaxCurrency <= 0 {
+		return
+	}
+
+	// The middle can move past a level held back as it is not on the
+	// exchange to fill, so put the waiting levels back on their side.
+	middle := 0
+	for i, order := range orders {
+		if order.Middle {
+			middle = i
+		}
+	}
+	for i, order := range orders {
+		if waiting(order) && !order.Flipped {
+			orders[i].Buy = i > middle
+		}
+	}
+
+	var buys, sells []int
+
+	if b.conf.MaxAsset > 0 {
+		room := b.conf.MaxAsset - b.Position
+		for _, order := range orders {
+			if order.Buy && resting(order) {
+				room -= order.Quantity
+			}
+		}
+
+		// Buys nearest the middle have the highest rates and come first
+		for i, order := range orders {
+			if !order.Buy || !waiting(order) {
+				continue
+			}
+			if len(buys) > 0 || order.Quantity > room {
+				buys = append(buys, i)
+				continue
+			}
+			room -= order.Quantity
+		}
+	}
+
+	if b.conf.MaxCurrency > 0 {
+		room := b.conf.MaxCurrency - b.Proceeds
+		for _, order := range orders {
+			if !order.Buy && resting(order) {
+				room -= order.Quantity * order.Rate
+			}
+		}
+
+		for i := len(orders) - 1; i >= 0; i-- {
+			order := orders[i]
+			if order.Buy || !waiting(order) {
+				continue
+			}
+			if len(sells) > 0 || order.Quantity*order.Rate > room {
+				sells = append(sells, i)
+				continue
+			}
+			room -= order.Quantity * order.Rate
+		}
+	}
+
+	for _, i := range append(buys, sells...) {
+		orders[i].Virtual = true
+		orders[i].Filled = false
+		orders[i].UID = ""
+	}
+
+	b.report(orders, buys, sells)
+}
+
+func resting(order Order) bool {
+	return order.UID != "" && !order.Filled && !order.Middle && !order.Virtual
+}
+
+func waiting(order Order) bool {
+	return (order.Filled || order.UID == "") && !order.Middle && !order.Virtual
+}
+
+// report logs the levels held back by the inventory limits whenever they
+// change.
+func (b *Book) report(orders []Order, buys []int, sells []int) {
+	var held []string
+	for _, side := range []struct {
+		name   string
+		levels []int
+	}{{"buys", buys}, {"sells", sells}} {
+		if len(side.levels) > 0 {
+			first, last := orders[side.levels[0]].Rate, orders[side.levels[len(side.levels)-1]].Rate
+			held = append(held, fmt.Sprintf("%d %s from %f to %f", len(side.levels), side.name, first, last))
+		}
+	}
+
+	report := strings.Join(held, ", ")
+	if report == b.held {
+		return
+	}
+	b.held = report
+
+	if report == "" {
+		log.Printf("%s: inventory limits no longer hold back any level", b.Market)
+		return
+	}
+	log.Printf("%s: inventory limits hold back %s", b.Market, report)
+}
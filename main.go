@@ -17,6 +17,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>. */
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -30,6 +31,28 @@ func main() {
 	log.Printf("This is free software, and you are welcome to redistribute it under certain conditions.")
 	log.Printf("Read the LICENSE and README for more details.")
 
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "backtest":
+			err = backtest(os.Args[2:])
+		case "migrate":
+			err = migration(os.Args[2:])
+		case "resume":
+			err = resume(os.Args[2:])
+		case "simulate":
+			err = simulate(os.Args[2:])
+		case "sweep":
+			err = sweep(os.Args[2:])
+		default:
+			err = fmt.Errorf("Unknown command: %s", os.Args[1])
+		}
+		if err != nil {
+			log.Printf("%v", err)
+		}
+		return
+	}
+
 	books, err := Load()
 	if err != nil {
 		log.Printf("%v", err)
@@ -0,0 +1,227 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Params are the parameters of a market that lay out its ladder.
+type Params struct {
+	High     float64
+	Low      float64
+	Start    float64
+	Interval float64
+	Quantity float64
+}
+
+func (m Market) params() Params {
+	return Params{m.High, m.Low, m.Start, m.Interval, m.Quantity}
+}
+
+func (b *Book) params() Params {
+	return Params{b.High, b.Low, b.Start, b.Interval, b.Quantity}
+}
+
+// configured returns m with a range given relative to the price resolved
+// against the Start the book was built at.
+func (b *Book) configured(m Market) Market {
+	if m.relative() || m.Start == 0 {
+		m.resolve(b.Built.Start)
+	}
+	return m
+}
+
+// changes describes how the config m differs from the config the book was
+// last built from. The book's own parameters are not compared as trailing,
+// adapting and its schedule move them away from the config.
+func (b *Book) changes(m Market) []string {
+	conf := b.configured(m)
+
+	var changes []string
+	for _, p := range []struct {
+		name string
+		old  float64
+		new  float64
+	}{
+		{"High", b.Built.High, conf.High},
+		{"Low", b.Built.Low, conf.Low},
+		{"Start", b.Built.Start, conf.Start},
+		{"Interval", b.Built.Interval, conf.Interval},
+		{"Quantity", b.Built.Quantity, conf.Quantity},
+		{"SizeFactor", b.SizeFactor, conf.SizeFactor},
+	} {
+		if !same(p.old, p.new) {
+			changes = append(changes, fmt.Sprintf("%s %f -> %f", p.name, p.old, p.new))
+		}
+	}
+
+	if spacing(b.Spacing) != spacing(conf.Spacing) {
+		changes = append(changes, fmt.Sprintf("Spacing %s -> %s", spacing(b.Spacing), spacing(conf.Spacing)))
+	} else if !sameRates(b.Levels, conf.Levels) {
+		changes = append(changes, "Levels changed")
+	}
+	if strategyName(b.Strategy) != strategyName(conf.Strategy) {
+		changes = append(changes, fmt.Sprintf("Strategy %s -> %s", strategyName(b.Strategy), strategyName(conf.Strategy)))
+	}
+	if sizing(b.Sizing) != sizing(conf.Sizing) {
+		changes = append(changes, fmt.Sprintf("Sizing %s -> %s", sizing(b.Sizing), sizing(conf.Sizing)))
+	}
+
+	return changes
+}
+
+// unchanged returns m with the parameters that lay out the ladder put back
+// to those the book was built from, so that a change waiting to be migrated
+// does not reach the book through its config.
+func (b *Book) unchanged(m Market) Market {
+	m.High, m.Low, m.Start = b.Built.High, b.Built.Low, b.Built.Start
+	m.Interval, m.Quantity = b.Built.Interval, b.Built.Quantity
+	m.autoStart, m.highOffset, m.lowOffset = false, nil, nil
+	m.Spacing, m.Levels = b.Spacing, b.Levels
+	m.Sizing, m.SizeFactor = b.Sizing, b.SizeFactor
+	m.Strategy = b.Strategy
+	return m
+}
+
+// apply moves the parameters of the book to the config m. Those the config
+// did not change keep the values the book has drifted to, and a profile in
+// force keeps the Interval and Quantity it sets.
+func (b *Book) apply(m Market) error {
+	conf := b.configured(m)
+
+	if strategyName(b.Strategy) != strategyName(conf.Strategy) {
+		strategy, err := NewStrategy(conf.Strategy)
+		if err != nil {
+			return err
+		}
+		b.Strategy, b.strategy = conf.Strategy, strategy
+	}
+
+	p := conf.params()
+	profile := conf.Profiles[b.Profile]
+
+	if !same(p.High, b.Built.High) || !same(p.Low, b.Built.Low) {
+		b.High, b.Low, b.Shift = p.High, p.Low, 0
+	}
+	if !same(p.Start, b.Built.Start) {
+		b.Start = p.Start
+	}
+	if !same(p.Interval, b.Built.Interval) && profile.Interval == 0 {
+		b.Interval = p.Interval
+	}
+	if !same(p.Quantity, b.Built.Quantity) && profile.Quantity == 0 {
+		b.Quantity = p.Quantity
+	}
+	b.Spacing, b.Levels = conf.Spacing, conf.Levels
+	b.Sizing, b.SizeFactor = conf.Sizing, conf.SizeFactor
+
+	b.Built = p
+	b.conf = conf
+
+	return nil
+}
+
+// migration prints how the book of each market in config.json whose config
+// has changed would be migrated and, with -apply, migrates it.
+func migration(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "migrate the books, cancelling and placing their orders on the exchange")
+	fs.Parse(args)
+
+	var conf Config
+	if err := LoadStruct("./config.json", &conf); err != nil {
+		return err
+	}
+
+	exchange, err := connect(conf)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range conf.Markets {
+		b, loaded, err := openBook(m, exchange, bookFile(exchange.Name(), m.Market))
+		if err != nil {
+			return err
+		}
+		if !loaded {
+			fmt.Printf("%s: no book, would build %d levels\n", m.Market, len(b.Orders))
+			continue
+		}
+
+		changes := b.changes(m)
+		if len(changes) == 0 {
+			fmt.Printf("%s: up to date\n", m.Market)
+			continue
+		}
+		fmt.Printf("%s: %s\n", m.Market, strings.Join(changes, ", "))
+
+		old := b.Orders
+		if err := b.apply(m); err != nil {
+			return err
+		}
+		orders, err := b.plan(b.middle())
+		if err != nil {
+			return err
+		}
+
+		kept := map[string]bool{}
+		keep, place := 0, 0
+		for _, order := range orders {
+			switch {
+			case order.Middle:
+				fmt.Printf("  middle %f\n", order.Rate)
+			case order.Filled:
+				fmt.Printf("  place  %s %f at %f\n", side(order.Buy), order.Quantity, order.Rate)
+				place++
+			default:
+				fmt.Printf("  keep   %s %f at %f\n", side(order.Buy), order.Quantity, order.Rate)
+				kept[order.UID] = true
+				keep++
+			}
+		}
+
+		cancel := 0
+		for _, order := range old {
+			if order.UID != "" && !order.Filled && !order.Middle && !kept[order.UID] {
+				fmt.Printf("  cancel %s %f at %f\n", side(order.Buy), order.Quantity, order.Rate)
+				cancel++
+			}
+		}
+
+		fmt.Printf("  keeps %d, cancels %d, places %d\n", keep, cancel, place)
+
+		if *apply {
+			if err := b.migrate(b.middle()); err != nil {
+				return err
+			}
+			b.save()
+			fmt.Printf("  migrated\n")
+		}
+	}
+
+	return nil
+}
+
+func side(buy bool) string {
+	if buy {
+		return "buy"
+	}
+	return "sell"
+}
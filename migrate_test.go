@@ -0,0 +1,79 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPendingMigration(t *testing.T) {
+	quiet(t)
+
+	path := filepath.Join(t.TempDir(), "book")
+	m := testMarket()
+	_, paper := testPaper(m)
+
+	b := testBook(t, m, paper, path)
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := paper.GetOrders(m.Market)
+
+	// A restart with a changed config keeps trading the persisted ladder
+	m.Quantity = 0.008
+	b = testBook(t, m, paper, path)
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Quantity != 0.004 {
+		t.Fatalf("Quantity %f changed without migrate -apply", b.Quantity)
+	}
+	if after, _ := paper.GetOrders(m.Market); len(after) != len(before) {
+		t.Fatalf("%d orders open before the restart, %d after", len(before), len(after))
+	}
+	if err := b.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// migrate -apply
+	b, _, err := openBook(m, paper, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.apply(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.migrate(b.middle()); err != nil {
+		t.Fatal(err)
+	}
+	b.save()
+
+	b = testBook(t, m, paper, path)
+	if changes := b.changes(m); len(changes) > 0 {
+		t.Fatalf("changes left after migrating: %v", changes)
+	}
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Quantity != m.Quantity {
+		t.Fatalf("Quantity %f after migrating", b.Quantity)
+	}
+	if err := b.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// Trip is a buy and a sell of the same quantity paired by the pairs
+// strategy, with the UIDs of the fills that opened and closed it.
+type Trip struct {
+	Open     string
+	Close    string
+	Buy      float64
+	Sell     float64
+	Quantity float64
+	Profit   float64
+}
+
+// Pairs lays out its levels like the grid but pairs every fill explicitly.
+// A fill opens a pair by placing the other side one level over, across the
+// middle, for the quantity that filled. When that order fills the pair is
+// closed, recorded in the book's Trips, and the level it came from is opened
+// again with its usual size.
+type Pairs struct {
+	grid Grid
+}
+
+func (p *Pairs) Build(b *Book, middle float64) ([]Order, error) {
+	return p.grid.Build(b, middle)
+}
+
+func (p *Pairs) OnFills(b *Book, filled []int) error {
+	if len(filled) == 0 {
+		return nil
+	}
+
+	middle := 0
+	for i, order := range b.Orders {
+		if order.Middle {
+			middle = i
+		}
+	}
+
+	// Work outwards from the middle so each fill finds the level next to it
+	// freed by the one before
+	distance := func(i int) int {
+		if i < middle {
+			return middle - i
+		}
+		return i - middle
+	}
+	sort.Slice(filled, func(x, y int) bool {
+		return distance(filled[x]) < distance(filled[y])
+	})
+
+	// The usual size of each level, built when first needed
+	var ladder []Order
+	size := func(i int) (float64, error) {
+		if ladder == nil {
+			var err error
+			ladder, err = p.grid.Build(b, b.Orders[middle].Rate)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if len(ladder) != len(b.Orders) {
+			return b.Quantity / b.Orders[i].Rate, nil
+		}
+		return ladder[i].Quantity, nil
+	}
+
+	for _, i := range filled {
+		order := b.Orders[i]
+
+		if order.Pair != "" && !order.Flipped {
+			p.trip(b, order)
+		}
+
+		next := i + 1
+		if order.Buy {
+			next = i - 1
+		}
+
+		if next != middle || order.Flipped {
+			// The level next to the fill is taken, so open the level again
+			// on its side of the middle
+			log.Printf("%s: fill at %f could not be paired", b.Market, order.Rate)
+
+			quantity, err := size(i)
+			if err != nil {
+				return err
+			}
+			b.Orders[i] = Order{Buy: i > middle, Rate: order.Rate, Quantity: quantity, Filled: true}
+			continue
+		}
+
+		pair := Order{Buy: !order.Buy, Rate: b.Orders[next].Rate, Filled: true}
+		if order.Pair == "" {
+			pair.Quantity, pair.Pair, pair.Opened = order.Quantity, order.UID, order.Rate
+		} else {
+			quantity, err := size(next)
+			if err != nil {
+				return err
+			}
+			pair.Quantity = quantity
+		}
+
+		b.Orders[next] = pair
+		b.Orders[i] = Order{Rate: order.Rate, Quantity: order.Quantity, Middle: true}
+		middle = i
+	}
+
+	return nil
+}
+
+// trip records the pair closed by the fill of order.
+func (p *Pairs) trip(b *Book, order Order) {
+	t := Trip{
+		Open:     order.Pair,
+		Close:    order.UID,
+		Buy:      order.Opened,
+		Sell:     order.Rate,
+		Quantity: order.Quantity,
+	}
+	if order.Buy {
+		t.Buy, t.Sell = order.Rate, order.Opened
+	}
+	t.Profit = (t.Sell - t.Buy) * t.Quantity
+
+	b.Trips = append(b.Trips, t)
+
+	log.Printf("%s: round trip of %f bought at %f and sold at %f, profit: %f", b.Market, t.Quantity, t.Buy, t.Sell, t.Profit)
+}
+
+func (p *Pairs) OnTicker(b *Book, ticker Ticker) error {
+	return nil
+}
+
+func (p *Pairs) Orders(b *Book) ([]Order, error) {
+	return append([]Order(nil), b.Orders...), nil
+}
@@ -0,0 +1,312 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type PaperConfig struct {
+	Feed     string
+	Cassette string
+	Fee      float64
+	Balances map[string]float64
+}
+
+type PaperOrder struct {
+	UID      string
+	Market   string
+	Buy      bool
+	Quantity float64
+	Rate     float64
+}
+
+type PaperFill struct {
+	PaperOrder
+	Fee  float64
+	Time time.Time
+}
+
+// Paper is a simulated exchange. It keeps virtual balances and resting
+// orders and fills an order in full once the ticker of its price feed crosses
+// the order's rate. Fees are charged on the proceeds of each fill.
+type Paper struct {
+	Balances map[string]float64
+	Initial  map[string]float64
+	Orders   map[string]PaperOrder
+	Fills    []PaperFill
+	Fee      float64
+	NextUID  int
+
+	feed    Exchange
+	tickers map[string]Ticker
+	path    string
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// NewPaper returns a paper exchange priced by feed. If path is not empty the
+// state of the exchange is loaded from and persisted to it, otherwise the
+// exchange starts with the given balances and is kept in memory only.
+func NewPaper(feed Exchange, fee float64, balances map[string]float64, path string) *Paper {
+	p := &Paper{
+		Balances: map[string]float64{},
+		Orders:   map[string]PaperOrder{},
+		Fee:      fee,
+		feed:     feed,
+		tickers:  map[string]Ticker{},
+		path:     path,
+		now:      time.Now,
+	}
+
+	if path != "" {
+		err := LoadStruct(path, p)
+		if err == nil {
+			p.Fee = fee
+			return p
+		}
+		log.Printf("%v", err)
+	}
+
+	p.Initial = map[string]float64{}
+	for asset, bal := range balances {
+		p.Balances[asset] = bal
+		p.Initial[asset] = bal
+	}
+
+	return p
+}
+
+func (p *Paper) Name() string {
+	return "paper"
+}
+
+func (p *Paper) save() {
+	if p.path == "" {
+		return
+	}
+
+	if err := SaveStruct(p.path, p); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// update fetches the ticker for market from the feed and fills every
+// resting order it crosses. The caller must hold p.mu.
+func (p *Paper) update(market string) (Ticker, error) {
+	ticker, err := p.feed.GetTicker(market)
+	if err != nil {
+		return Ticker{}, err
+	}
+	p.tickers[market] = ticker
+
+	filled := false
+	for _, uid := range p.open(market) {
+		order := p.Orders[uid]
+
+		if order.Buy && (ticker.Ask > 0 && ticker.Ask <= order.Rate || ticker.Last > 0 && ticker.Last < order.Rate) ||
+			!order.Buy && (ticker.Bid >= order.Rate || ticker.Last > order.Rate) {
+			p.fill(uid)
+			filled = true
+		}
+	}
+
+	if filled {
+		p.save()
+	}
+
+	return ticker, nil
+}
+
+// fill executes the whole of a resting order. The caller must hold p.mu.
+func (p *Paper) fill(uid string) {
+	order := p.Orders[uid]
+	delete(p.Orders, uid)
+
+	asset, currency := splitMarket(order.Market)
+
+	var fee float64
+	if order.Buy {
+		fee = order.Quantity * p.Fee
+		p.Balances[asset] += order.Quantity - fee
+	} else {
+		fee = order.Quantity * order.Rate * p.Fee
+		p.Balances[currency] += order.Quantity*order.Rate - fee
+	}
+
+	p.Fills = append(p.Fills, PaperFill{order, fee, p.now()})
+
+	log.Printf("Paper fill: %+v, fee: %f", order, fee)
+}
+
+func (p *Paper) PlaceOrder(buy bool, market string, quantity float64, rate float64) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ticker, ok := p.tickers[market]
+	if !ok {
+		var err error
+		ticker, err = p.update(market)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if buy && ticker.Ask > 0 && rate >= ticker.Ask || !buy && rate <= ticker.Bid {
+		return "", errors.New("POST_ONLY_FAILED")
+	}
+
+	asset, currency := splitMarket(market)
+	if buy {
+		if p.Balances[currency] < quantity*rate {
+			return "", errors.New("INSUFFICIENT_FUNDS")
+		}
+		p.Balances[currency] -= quantity * rate
+	} else {
+		if p.Balances[asset] < quantity {
+			return "", errors.New("INSUFFICIENT_FUNDS")
+		}
+		p.Balances[asset] -= quantity
+	}
+
+	p.NextUID++
+	uid := fmt.Sprintf("paper-%d", p.NextUID)
+	p.Orders[uid] = PaperOrder{uid, market, buy, quantity, rate}
+
+	p.save()
+
+	return uid, nil
+}
+
+// Take trades quantity against the ticker of the feed, buying at the ask or
+// selling at the bid, and charges the fee on the proceeds.
+func (p *Paper) Take(buy bool, market string, quantity float64) (float64, float64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ticker, err := p.update(market)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rate := ticker.Bid
+	if buy {
+		rate = ticker.Ask
+	}
+	if rate <= 0 {
+		return 0, 0, errors.New("NO_LIQUIDITY")
+	}
+
+	asset, currency := splitMarket(market)
+	if buy && p.Balances[currency] < quantity*rate || !buy && p.Balances[asset] < quantity {
+		return 0, 0, errors.New("INSUFFICIENT_FUNDS")
+	}
+
+	p.NextUID++
+	order := PaperOrder{fmt.Sprintf("paper-%d", p.NextUID), market, buy, quantity, rate}
+
+	var fee float64
+	if buy {
+		fee = quantity * p.Fee
+		p.Balances[currency] -= quantity * rate
+		p.Balances[asset] += quantity - fee
+	} else {
+		fee = quantity * rate * p.Fee
+		p.Balances[asset] -= quantity
+		p.Balances[currency] += quantity*rate - fee
+	}
+
+	p.Fills = append(p.Fills, PaperFill{order, fee, p.now()})
+
+	log.Printf("Paper take: %+v, fee: %f", order, fee)
+
+	p.save()
+
+	return quantity, rate, nil
+}
+
+func (p *Paper) GetOrders(market string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.update(market); err != nil {
+		return nil, err
+	}
+
+	return p.open(market), nil
+}
+
+// open returns the UIDs of the resting orders in market in the order they
+// were placed. The caller must hold p.mu.
+func (p *Paper) open(market string) []string {
+	var ret []string
+	for uid, order := range p.Orders {
+		if order.Market == market {
+			ret = append(ret, uid)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i]) != len(ret[j]) {
+			return len(ret[i]) < len(ret[j])
+		}
+		return ret[i] < ret[j]
+	})
+
+	return ret
+}
+
+func (p *Paper) CancelOrder(UID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order, ok := p.Orders[UID]
+	if !ok {
+		return errors.New("ORDER_NOT_OPEN")
+	}
+	delete(p.Orders, UID)
+
+	asset, currency := splitMarket(order.Market)
+	if order.Buy {
+		p.Balances[currency] += order.Quantity * order.Rate
+	} else {
+		p.Balances[asset] += order.Quantity
+	}
+
+	p.save()
+
+	return nil
+}
+
+func (p *Paper) GetTicker(market string) (Ticker, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.update(market)
+}
+
+func (p *Paper) GetBalance(asset string) (float64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.Balances[asset], nil
+}
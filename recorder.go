@@ -0,0 +1,253 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// Interaction is a single call made against an exchange along with the
+// response it returned. A cassette file is a stream of Interactions, one JSON
+// object per line, in the order the calls completed.
+type Interaction struct {
+	Exchange string
+	Method   string
+	Buy      bool     `json:",omitempty"`
+	Market   string   `json:",omitempty"`
+	Quantity float64  `json:",omitempty"`
+	Rate     float64  `json:",omitempty"`
+	UID      string   `json:",omitempty"`
+	Asset    string   `json:",omitempty"`
+	Result   string   `json:",omitempty"`
+	Orders   []string `json:",omitempty"`
+	Ticker   *Ticker  `json:",omitempty"`
+	Balance  float64  `json:",omitempty"`
+	Error    string   `json:",omitempty"`
+}
+
+// matches reports whether two interactions are the same call, ignoring the
+// response.
+func (i Interaction) matches(o Interaction) bool {
+	return i.Method == o.Method && i.Buy == o.Buy && i.Market == o.Market &&
+		i.Quantity == o.Quantity && i.Rate == o.Rate && i.UID == o.UID &&
+		i.Asset == o.Asset
+}
+
+func (i Interaction) err() error {
+	if i.Error == "" {
+		return nil
+	}
+	return errors.New(i.Error)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Recorder wraps an Exchange and appends every call made through it to a
+// cassette file.
+type Recorder struct {
+	ex  Exchange
+	out *os.File
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+func NewRecorder(exchange Exchange, filename string) (*Recorder, error) {
+	out, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{ex: exchange, out: out, enc: json.NewEncoder(out)}, nil
+}
+
+func (r *Recorder) record(i Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i.Exchange = r.ex.Name()
+	if err := r.enc.Encode(i); err != nil {
+		log.Printf("cassette: %v", err)
+	}
+}
+
+// Close closes the underlying cassette file.
+func (r *Recorder) Close() error {
+	return r.out.Close()
+}
+
+func (r *Recorder) Name() string {
+	return r.ex.Name()
+}
+
+func (r *Recorder) PlaceOrder(buy bool, market string, quantity float64, rate float64) (string, error) {
+	uid, err := r.ex.PlaceOrder(buy, market, quantity, rate)
+	r.record(Interaction{Method: "PlaceOrder", Buy: buy, Market: market, Quantity: quantity, Rate: rate, Result: uid, Error: errString(err)})
+	return uid, err
+}
+
+func (r *Recorder) GetOrders(market string) ([]string, error) {
+	orders, err := r.ex.GetOrders(market)
+	r.record(Interaction{Method: "GetOrders", Market: market, Orders: orders, Error: errString(err)})
+	return orders, err
+}
+
+func (r *Recorder) CancelOrder(UID string) error {
+	err := r.ex.CancelOrder(UID)
+	r.record(Interaction{Method: "CancelOrder", UID: UID, Error: errString(err)})
+	return err
+}
+
+func (r *Recorder) GetTicker(market string) (Ticker, error) {
+	ticker, err := r.ex.GetTicker(market)
+	r.record(Interaction{Method: "GetTicker", Market: market, Ticker: &ticker, Error: errString(err)})
+	return ticker, err
+}
+
+func (r *Recorder) GetBalance(asset string) (float64, error) {
+	bal, err := r.ex.GetBalance(asset)
+	r.record(Interaction{Method: "GetBalance", Asset: asset, Balance: bal, Error: errString(err)})
+	return bal, err
+}
+
+// Replayer is an Exchange that serves the responses stored in a cassette.
+// Each call is answered by the first unused recorded interaction with the same
+// method and arguments, so books ticking concurrently during the recording
+// replay the same way. A call with no matching recording returns an error.
+type Replayer struct {
+	name         string
+	interactions []Interaction
+	used         []bool
+	mu           sync.Mutex
+}
+
+func NewReplayer(filename string) (*Replayer, error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	r := &Replayer{}
+	dec := json.NewDecoder(in)
+	for {
+		var i Interaction
+		err := dec.Decode(&i)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.interactions = append(r.interactions, i)
+	}
+
+	if len(r.interactions) == 0 {
+		return nil, fmt.Errorf("cassette %s is empty", filename)
+	}
+
+	r.name = r.interactions[0].Exchange
+	r.used = make([]bool, len(r.interactions))
+
+	return r, nil
+}
+
+func (r *Replayer) next(call Interaction) (Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n, i := range r.interactions {
+		if !r.used[n] && i.matches(call) {
+			r.used[n] = true
+			return i, nil
+		}
+	}
+
+	return Interaction{}, fmt.Errorf("cassette: no recorded call for %+v", call)
+}
+
+// Remaining returns the number of recorded interactions that have not been
+// replayed yet.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, used := range r.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Replayer) Name() string {
+	return r.name
+}
+
+func (r *Replayer) PlaceOrder(buy bool, market string, quantity float64, rate float64) (string, error) {
+	i, err := r.next(Interaction{Method: "PlaceOrder", Buy: buy, Market: market, Quantity: quantity, Rate: rate})
+	if err != nil {
+		return "", err
+	}
+	return i.Result, i.err()
+}
+
+func (r *Replayer) GetOrders(market string) ([]string, error) {
+	i, err := r.next(Interaction{Method: "GetOrders", Market: market})
+	if err != nil {
+		return nil, err
+	}
+	return i.Orders, i.err()
+}
+
+func (r *Replayer) CancelOrder(UID string) error {
+	i, err := r.next(Interaction{Method: "CancelOrder", UID: UID})
+	if err != nil {
+		return err
+	}
+	return i.err()
+}
+
+func (r *Replayer) GetTicker(market string) (Ticker, error) {
+	i, err := r.next(Interaction{Method: "GetTicker", Market: market})
+	if err != nil {
+		return Ticker{}, err
+	}
+	if i.Ticker == nil {
+		return Ticker{}, i.err()
+	}
+	return *i.Ticker, i.err()
+}
+
+func (r *Replayer) GetBalance(asset string) (float64, error) {
+	i, err := r.next(Interaction{Method: "GetBalance", Asset: asset})
+	if err != nil {
+		return 0, err
+	}
+	return i.Balance, i.err()
+}
@@ -0,0 +1,74 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestReplay records a session of a book ticking against a paper exchange
+// and checks that a book ticking against the cassette makes the same
+// decisions.
+func TestReplay(t *testing.T) {
+	quiet(t)
+
+	cassette := filepath.Join(t.TempDir(), "session.cassette")
+	m := testMarket()
+
+	feed, paper := testPaper(m)
+	randomWalk(feed, m, 1)
+	rec, err := NewRecorder(paper, cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := testBook(t, m, rec, "")
+
+	var recorded [][]Order
+	for i := 0; i < 100; i++ {
+		feed.next()
+		if err := b.Tick(); err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, append([]Order(nil), b.Orders...))
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplayer(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = testBook(t, m, replay, "")
+
+	for i := range recorded {
+		if err := b.Tick(); err != nil {
+			t.Fatalf("tick %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(b.Orders, recorded[i]) {
+			t.Fatalf("tick %d: replayed book differs from the recording", i)
+		}
+	}
+
+	if n := replay.Remaining(); n != 0 {
+		t.Errorf("%d recorded calls were not replayed", n)
+	}
+}
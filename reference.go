@@ -0,0 +1,154 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReferenceConfig is a price source for a market: the middle of the ticker
+// of Market on Exchange, or on the book's own exchange if Exchange is empty,
+// the weighted average of the sources in Blend, or the product of the
+// sources in Cross. An inverted source is divided by instead, so VTCBTC can
+// be made from a Cross of VTCEUR and an inverted BTCEUR.
+type ReferenceConfig struct {
+	Exchange string
+	Market   string
+	Weight   float64
+	Invert   bool
+	Blend    []ReferenceConfig
+	Cross    []ReferenceConfig
+
+	// MaxDeviation is the largest fraction the market's own price may be
+	// away from the reference before the book stops placing orders.
+	MaxDeviation float64
+}
+
+// Reference is the price source set by a ReferenceConfig.
+type Reference struct {
+	Conf ReferenceConfig
+
+	ex    Exchange
+	parts []*Reference
+}
+
+// NewReference returns the price source for conf. Sources with no Exchange
+// use exchange and other exchanges are connected to without keys, which is
+// enough to read their tickers.
+func NewReference(conf ReferenceConfig, exchange Exchange) (*Reference, error) {
+	return newReference(conf, exchange, map[string]Exchange{})
+}
+
+func newReference(conf ReferenceConfig, exchange Exchange, venues map[string]Exchange) (*Reference, error) {
+	r := &Reference{Conf: conf, ex: exchange}
+
+	parts := conf.Blend
+	if len(conf.Cross) > 0 {
+		if len(parts) > 0 {
+			return nil, errors.New("A reference cannot both Blend and Cross")
+		}
+		parts = conf.Cross
+	}
+
+	if len(parts) == 0 {
+		if conf.Market == "" {
+			return nil, errors.New("A reference needs a Market, a Blend or a Cross")
+		}
+
+		if conf.Exchange != "" {
+			ex, ok := venues[conf.Exchange]
+			if !ok {
+				var err error
+				ex, err = Connect(conf.Exchange, "", "")
+				if err != nil {
+					return nil, err
+				}
+				venues[conf.Exchange] = ex
+			}
+			r.ex = ex
+		}
+
+		return r, nil
+	}
+
+	for _, part := range parts {
+		p, err := newReference(part, exchange, venues)
+		if err != nil {
+			return nil, err
+		}
+		r.parts = append(r.parts, p)
+	}
+
+	return r, nil
+}
+
+// Price returns the current reference price.
+func (r *Reference) Price() (float64, error) {
+	var price float64
+
+	switch {
+	case len(r.parts) == 0:
+		ticker, err := r.ex.GetTicker(r.Conf.Market)
+		if err != nil {
+			return 0, err
+		}
+		price = (ticker.Bid + ticker.Ask) / 2
+	case len(r.Conf.Cross) > 0:
+		price = 1
+		for _, part := range r.parts {
+			p, err := part.Price()
+			if err != nil {
+				return 0, err
+			}
+			price *= p
+		}
+	default:
+		var weights float64
+		for _, part := range r.parts {
+			p, err := part.Price()
+			if err != nil {
+				return 0, err
+			}
+
+			w := part.Conf.Weight
+			if w == 0 {
+				w = 1
+			}
+			price += w * p
+			weights += w
+		}
+		price /= weights
+	}
+
+	if price <= 0 {
+		return 0, fmt.Errorf("Reference price of %s is %f", r.name(), price)
+	}
+
+	if r.Conf.Invert {
+		price = 1 / price
+	}
+
+	return price, nil
+}
+
+func (r *Reference) name() string {
+	if r.Conf.Market == "" {
+		return "a blend or cross"
+	}
+	return r.Conf.Market
+}
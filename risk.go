@@ -0,0 +1,156 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// RiskConfig halts a market once the value of its book falls more than
+// MaxDrawdown, a fraction, below the highest value it has had. Quote is the
+// price of the market's currency in the currency the book is valued in;
+// without it the book is valued in the market's currency.
+type RiskConfig struct {
+	MaxDrawdown float64
+	Quote       *ReferenceConfig
+}
+
+// value returns the book's own asset and currency valued at price, in the
+// quote currency of its risk config. The book holds what its levels had when
+// the drawdown was first measured, in Asset and Currency, plus the Position
+// and Proceeds its fills have added since. The rest of the account, which
+// other books or the operator may be trading, is left out.
+func (b *Book) value(price float64) (float64, error) {
+	value := (b.Asset+b.Position)*price + b.Currency + b.Proceeds
+	if b.quote != nil {
+		q, err := b.quote.Price()
+		if err != nil {
+			return 0, err
+		}
+		value *= q
+	}
+
+	return value, nil
+}
+
+// drawdown records the peak value of the book and halts it when its value
+// has fallen more than MaxDrawdown below it.
+func (b *Book) drawdown(ticker Ticker) error {
+	if b.conf.Risk == nil || b.conf.Risk.MaxDrawdown <= 0 {
+		return nil
+	}
+
+	// Measure from the capital on the levels now, less what the fills
+	// counted so far brought in
+	if b.Peak == 0 {
+		currency, asset := b.Capital()
+		b.Asset, b.Currency = asset-b.Position, currency-b.Proceeds
+	}
+
+	value, err := b.value(b.price(ticker))
+	if err != nil {
+		return err
+	}
+
+	if value > b.Peak {
+		b.Peak = value
+		return nil
+	}
+
+	if dd := (b.Peak - value) / b.Peak; dd > b.conf.Risk.MaxDrawdown {
+		b.halt(fmt.Sprintf("value %f is %.1f%% below its peak of %f", value, 100*dd, b.Peak))
+		return fmt.Errorf("%s: halted: %s", b.Market, b.Reason)
+	}
+
+	return nil
+}
+
+// halt cancels every order of the book on the exchange and stops it from
+// trading until it is resumed. The cancelled levels are placed again when it
+// is.
+func (b *Book) halt(reason string) {
+	log.Printf("%s: halting: %s", b.Market, reason)
+
+	for i, order := range b.Orders {
+		if order.UID == "" || order.Filled || order.Middle {
+			continue
+		}
+
+		if err := b.Ex.CancelOrder(order.UID); err != nil {
+			log.Printf("%+v", err)
+			continue
+		}
+
+		log.Printf("Cancelled Order: %+v", order)
+
+		b.Orders[i].UID = ""
+		b.Orders[i].Filled = !order.Virtual
+	}
+
+	b.Halted = true
+	b.Reason = reason
+}
+
+// resume clears the halt and circuit breaker of the books of the named
+// markets, or of every market in config.json if none are named, so they trade
+// again when the bot next starts. The drawdown of a resumed book is measured
+// from its value when it starts.
+func resume(args []string) error {
+	var conf Config
+	if err := LoadStruct("./config.json", &conf); err != nil {
+		return err
+	}
+
+	markets := args
+	if len(markets) == 0 {
+		for _, m := range conf.Markets {
+			markets = append(markets, m.Market)
+		}
+	}
+
+	for _, market := range markets {
+		path := bookFile(conf.Exchange, market)
+
+		var b Book
+		if err := LoadStruct(path, &b); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if !b.Halted && !b.Tripped {
+			continue
+		}
+
+		if b.Halted {
+			log.Printf("%s: resuming, was halted: %s", market, b.Reason)
+			b.Halted, b.Reason, b.Peak = false, "", 0
+			b.FirstRun = true
+		}
+		if b.Tripped {
+			log.Printf("%s: resetting circuit breaker", market)
+			b.Tripped, b.Calm = false, 0
+		}
+		if err := SaveStruct(path, &b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
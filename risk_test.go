@@ -0,0 +1,56 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestDrawdown(t *testing.T) {
+	quiet(t)
+
+	m := testMarket()
+	m.Risk = &RiskConfig{MaxDrawdown: 0.2}
+	feed, paper := testPaper(m)
+
+	b := testBook(t, m, paper, "")
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Funds taken off the account are not the book's to lose
+	paper.Balances["BTC"] /= 2
+	paper.Balances["VTC"] /= 2
+	if err := b.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Halted {
+		t.Fatalf("the book halted on the account's balances: %s", b.Reason)
+	}
+
+	// A collapse fills the buys and leaves the book holding the asset
+	for !b.Halted && feed.price > m.Low {
+		feed.price *= 0.97
+		b.Tick()
+	}
+	if !b.Halted {
+		t.Fatal("the book rode the price down without halting")
+	}
+	if open, _ := paper.GetOrders(m.Market); len(open) > 0 {
+		t.Fatalf("%d orders left open after halting", len(open))
+	}
+}
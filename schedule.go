@@ -0,0 +1,160 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron matches times against a cron expression of five fields: minute, hour,
+// day of the month, month and day of the week (0 or 7 is Sunday). Each field
+// is *, a number, a range a-b or a comma separated list of them, and any of
+// these may be followed by /n to take every nth value. As in cron, when both
+// days are restricted a time matches if either does.
+type Cron struct {
+	fields [5]map[int]bool
+	any    [5]bool
+}
+
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func ParseCron(expr string) (*Cron, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("Cron expression %q needs 5 fields", expr)
+	}
+
+	c := &Cron{}
+	for i, part := range parts {
+		c.fields[i] = map[int]bool{}
+		c.any[i] = part == "*"
+
+		for _, item := range strings.Split(part, ",") {
+			lo, hi, step := cronBounds[i][0], cronBounds[i][1], 1
+
+			if j := strings.Index(item, "/"); j >= 0 {
+				var err error
+				step, err = strconv.Atoi(item[j+1:])
+				if err != nil || step <= 0 {
+					return nil, fmt.Errorf("Bad step in cron expression %q", expr)
+				}
+				item = item[:j]
+			}
+
+			if item != "*" {
+				bounds := strings.SplitN(item, "-", 2)
+
+				var err error
+				lo, err = strconv.Atoi(bounds[0])
+				if err != nil {
+					return nil, fmt.Errorf("Bad value in cron expression %q", expr)
+				}
+				hi = lo
+				if len(bounds) == 2 {
+					hi, err = strconv.Atoi(bounds[1])
+					if err != nil {
+						return nil, fmt.Errorf("Bad value in cron expression %q", expr)
+					}
+				} else if step > 1 {
+					hi = cronBounds[i][1]
+				}
+			}
+
+			if lo < cronBounds[i][0] || hi > cronBounds[i][1] || lo > hi {
+				return nil, fmt.Errorf("Value out of range in cron expression %q", expr)
+			}
+
+			for v := lo; v <= hi; v += step {
+				c.fields[i][v] = true
+			}
+		}
+	}
+
+	if c.fields[4][7] {
+		c.fields[4][0] = true
+	}
+
+	return c, nil
+}
+
+// Match reports whether t falls in a minute matched by the expression.
+func (c *Cron) Match(t time.Time) bool {
+	if !c.fields[0][t.Minute()] || !c.fields[1][t.Hour()] || !c.fields[3][int(t.Month())] {
+		return false
+	}
+
+	dom := c.fields[2][t.Day()]
+	dow := c.fields[4][int(t.Weekday())]
+	if c.any[2] || c.any[4] {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// rule is a Rule of a book's schedule with its cron expression parsed.
+type rule struct {
+	cron    *Cron
+	profile string
+}
+
+// schedule switches the book to the profile of the first rule of its
+// schedule matching the current time, or back to the market's own
+// parameters if none does. A change of Interval or Quantity rebuilds the
+// ladder around the current middle.
+func (b *Book) schedule() error {
+	if len(b.rules) == 0 && b.Profile == "" {
+		return nil
+	}
+
+	name := ""
+	now := b.now()
+	for _, r := range b.rules {
+		if r.cron.Match(now) {
+			name = r.profile
+			break
+		}
+	}
+
+	b.profile = b.conf.Profiles[name]
+	if name == b.Profile {
+		return nil
+	}
+
+	log.Printf("%s: switching from profile %q to %q", b.Market, b.Profile, name)
+	b.Profile = name
+
+	interval, quantity := b.conf.Interval, b.conf.Quantity
+	if b.profile.Interval != 0 {
+		interval = b.profile.Interval
+	}
+	if b.profile.Quantity != 0 {
+		quantity = b.profile.Quantity
+	}
+
+	if interval == b.Interval && quantity == b.Quantity {
+		return nil
+	}
+
+	b.Interval, b.Quantity = interval, quantity
+
+	return b.migrate(b.middle())
+}
@@ -0,0 +1,394 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dust is the quantity below which an order is considered completely filled.
+const dust = 1e-12
+
+type simOrder struct {
+	UID      string
+	Owner    string
+	Buy      bool
+	Quantity float64
+	Rate     float64
+	seq      int64
+}
+
+// SimMarket is the limit order book of a single market in a Simulator.
+// Orders are matched with price-time priority and trade at the rate of the
+// resting order. Traders act on a SimMarket from within Simulator.Step.
+type SimMarket struct {
+	Name    string
+	bids    []*simOrder
+	asks    []*simOrder
+	last    float64
+	traders []Trader
+	owner   string
+	sim     *Simulator
+}
+
+// Trader is a synthetic participant in a simulated market. Trade is called
+// once every simulation step and may submit and cancel orders in m.
+type Trader interface {
+	Trade(m *SimMarket, rnd *rand.Rand, dt time.Duration)
+}
+
+// Simulator is an in-process exchange. The bot's orders rest in the order
+// books of each market alongside those of synthetic traders and are filled,
+// possibly partially, as the traders trade against them.
+type Simulator struct {
+	Balances map[string]float64
+	Fee      float64
+	Fills    []PaperFill
+	Now      time.Time
+
+	markets map[string]*SimMarket
+	rnd     *rand.Rand
+	seq     int64
+	mu      sync.Mutex
+}
+
+func NewSimulator(seed int64, fee float64, balances map[string]float64) *Simulator {
+	s := &Simulator{
+		Balances: map[string]float64{},
+		Fee:      fee,
+		Now:      time.Unix(0, 0).UTC(),
+		markets:  map[string]*SimMarket{},
+		rnd:      rand.New(rand.NewSource(seed)),
+	}
+
+	for asset, bal := range balances {
+		s.Balances[asset] = bal
+	}
+
+	return s
+}
+
+// AddMarket opens market with a last traded price of price. The traders act
+// in the market in the order given.
+func (s *Simulator) AddMarket(market string, price float64, traders ...Trader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.markets[market] = &SimMarket{Name: market, last: price, traders: traders, sim: s}
+}
+
+// Step advances the simulated clock by dt and lets every trader act once.
+func (s *Simulator) Step(dt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Now = s.Now.Add(dt)
+
+	names := make([]string, 0, len(s.markets))
+	for name := range s.markets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := s.markets[name]
+		for i, t := range m.traders {
+			m.owner = fmt.Sprintf("trader-%d", i)
+			t.Trade(m, s.rnd, dt)
+		}
+		m.owner = ""
+	}
+}
+
+// Run advances the simulation by duration in steps of step, ticking every
+// book once per tick of simulated time.
+func (s *Simulator) Run(books []*Book, duration time.Duration, step time.Duration, tick time.Duration) {
+	var next time.Duration
+	for t := time.Duration(0); t < duration; t += step {
+		s.Step(step)
+
+		if t >= next {
+			for _, b := range books {
+				if err := b.Tick(); err != nil {
+					log.Printf("%v", err)
+				}
+			}
+			next += tick
+		}
+	}
+}
+
+// Ticker returns the best bid and ask and the last traded price.
+func (m *SimMarket) Ticker() Ticker {
+	t := Ticker{Last: m.last}
+	if len(m.bids) > 0 {
+		t.Bid = m.bids[0].Rate
+	}
+	if len(m.asks) > 0 {
+		t.Ask = m.asks[0].Rate
+	}
+	return t
+}
+
+// Now returns the current simulated time.
+func (m *SimMarket) Now() time.Time {
+	return m.sim.Now
+}
+
+// Submit sends a limit order on behalf of the current trader. The order
+// trades against any resting orders it crosses and, if rest is set, the
+// remainder is added to the book. It returns the quantity executed.
+func (m *SimMarket) Submit(buy bool, quantity float64, rate float64, rest bool) float64 {
+	if quantity <= dust || rate <= 0 {
+		return 0
+	}
+
+	o := m.sim.newOrder(m.owner, buy, quantity, rate)
+	executed := m.match(o)
+	if rest && o.Quantity > dust {
+		m.insert(o)
+	}
+
+	return executed
+}
+
+// Cancel removes every resting order of the current trader.
+func (m *SimMarket) Cancel() {
+	keep := func(orders []*simOrder) []*simOrder {
+		var ret []*simOrder
+		for _, o := range orders {
+			if o.Owner != m.owner {
+				ret = append(ret, o)
+			}
+		}
+		return ret
+	}
+
+	m.bids = keep(m.bids)
+	m.asks = keep(m.asks)
+}
+
+// Quote replaces the current trader's resting orders with a few levels of
+// liquidity of around size either side of fair, after first trading the
+// market towards fair.
+func (m *SimMarket) Quote(fair float64, size float64, rnd *rand.Rand) {
+	m.Cancel()
+
+	ticker := m.Ticker()
+	if ticker.Ask > 0 && ticker.Ask < fair {
+		m.Submit(true, size*(1+rnd.Float64()), fair, false)
+	}
+	if ticker.Bid > fair {
+		m.Submit(false, size*(1+rnd.Float64()), fair, false)
+	}
+
+	for i := 1; i <= 3; i++ {
+		spread := fair * 0.001 * float64(i) * (0.5 + rnd.Float64())
+		m.Submit(true, size*rnd.Float64(), fair-spread, true)
+		m.Submit(false, size*rnd.Float64(), fair+spread, true)
+	}
+}
+
+func (s *Simulator) newOrder(owner string, buy bool, quantity float64, rate float64) *simOrder {
+	s.seq++
+	return &simOrder{fmt.Sprintf("sim-%d", s.seq), owner, buy, quantity, rate, s.seq}
+}
+
+func (m *SimMarket) match(o *simOrder) float64 {
+	book := &m.asks
+	if !o.Buy {
+		book = &m.bids
+	}
+
+	var executed float64
+	for o.Quantity > dust && len(*book) > 0 {
+		best := (*book)[0]
+		if o.Buy && best.Rate > o.Rate || !o.Buy && best.Rate < o.Rate {
+			break
+		}
+
+		qty := o.Quantity
+		if best.Quantity < qty {
+			qty = best.Quantity
+		}
+
+		o.Quantity -= qty
+		best.Quantity -= qty
+		executed += qty
+		m.last = best.Rate
+
+		if best.Owner == "" {
+			m.sim.fill(m.Name, best, qty)
+		}
+
+		if best.Quantity <= dust {
+			*book = (*book)[1:]
+		}
+	}
+
+	return executed
+}
+
+func (m *SimMarket) insert(o *simOrder) {
+	book := &m.asks
+	better := func(a, b *simOrder) bool { return a.Rate < b.Rate }
+	if o.Buy {
+		book = &m.bids
+		better = func(a, b *simOrder) bool { return a.Rate > b.Rate }
+	}
+
+	i := sort.Search(len(*book), func(i int) bool { return better(o, (*book)[i]) })
+	*book = append(*book, nil)
+	copy((*book)[i+1:], (*book)[i:])
+	(*book)[i] = o
+}
+
+// fill credits the bot for qty of its resting order o. The caller must hold
+// s.mu.
+func (s *Simulator) fill(market string, o *simOrder, qty float64) {
+	asset, currency := splitMarket(market)
+
+	var fee float64
+	if o.Buy {
+		fee = qty * s.Fee
+		s.Balances[asset] += qty - fee
+	} else {
+		fee = qty * o.Rate * s.Fee
+		s.Balances[currency] += qty*o.Rate - fee
+	}
+
+	s.Fills = append(s.Fills, PaperFill{PaperOrder{o.UID, market, o.Buy, qty, o.Rate}, fee, s.Now})
+}
+
+func (s *Simulator) market(market string) (*SimMarket, error) {
+	m, ok := s.markets[market]
+	if !ok {
+		return nil, errors.New("INVALID_MARKET")
+	}
+	return m, nil
+}
+
+func (s *Simulator) Name() string {
+	return "sim"
+}
+
+func (s *Simulator) PlaceOrder(buy bool, market string, quantity float64, rate float64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.market(market)
+	if err != nil {
+		return "", err
+	}
+
+	ticker := m.Ticker()
+	if buy && ticker.Ask > 0 && rate >= ticker.Ask || !buy && rate <= ticker.Bid {
+		return "", errors.New("POST_ONLY_FAILED")
+	}
+
+	asset, currency := splitMarket(market)
+	if buy {
+		if s.Balances[currency] < quantity*rate {
+			return "", errors.New("INSUFFICIENT_FUNDS")
+		}
+		s.Balances[currency] -= quantity * rate
+	} else {
+		if s.Balances[asset] < quantity {
+			return "", errors.New("INSUFFICIENT_FUNDS")
+		}
+		s.Balances[asset] -= quantity
+	}
+
+	o := s.newOrder("", buy, quantity, rate)
+	m.insert(o)
+
+	return o.UID, nil
+}
+
+func (s *Simulator) GetOrders(market string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.market(market)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, book := range [][]*simOrder{m.bids, m.asks} {
+		for _, o := range book {
+			if o.Owner == "" {
+				ret = append(ret, o.UID)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *Simulator) CancelOrder(UID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, m := range s.markets {
+		for _, book := range []*[]*simOrder{&m.bids, &m.asks} {
+			for i, o := range *book {
+				if o.Owner != "" || o.UID != UID {
+					continue
+				}
+
+				*book = append((*book)[:i], (*book)[i+1:]...)
+
+				asset, currency := splitMarket(name)
+				if o.Buy {
+					s.Balances[currency] += o.Quantity * o.Rate
+				} else {
+					s.Balances[asset] += o.Quantity
+				}
+
+				return nil
+			}
+		}
+	}
+
+	return errors.New("ORDER_NOT_OPEN")
+}
+
+func (s *Simulator) GetTicker(market string) (Ticker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.market(market)
+	if err != nil {
+		return Ticker{}, err
+	}
+
+	return m.Ticker(), nil
+}
+
+func (s *Simulator) GetBalance(asset string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Balances[asset], nil
+}
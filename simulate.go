@@ -0,0 +1,145 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// simulate runs the markets in config.json against the matching engine
+// simulator and prints a summary of each book.
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	duration := fs.Duration("duration", 24*time.Hour, "simulated time to run for")
+	step := fs.Duration("step", time.Second, "simulated time between trader actions")
+	tick := fs.Duration("tick", 3*time.Second, "simulated time between book ticks")
+	traders := fs.String("traders", "randomwalk", "comma separated traders in each market: randomwalk, trending, meanreverting, flashcrash")
+	volatility := fs.Float64("volatility", 0.02, "hourly volatility of the traders' fair value")
+	seed := fs.Int64("seed", 1, "random seed")
+	price := fs.String("price", "", "comma separated MARKET=PRICE starting prices for markets with an automatic Start or a relative High and Low")
+	verbose := fs.Bool("v", false, "log every book action")
+	fs.Parse(args)
+
+	var conf Config
+	err := LoadStruct("./config.json", &conf)
+	if err != nil {
+		return err
+	}
+
+	sim := NewSimulator(*seed, conf.Paper.Fee, conf.Paper.Balances)
+
+	var exchange Exchange = sim
+	if conf.Chaos != nil {
+		chaos := NewChaos(sim, *conf.Chaos)
+		chaos.sleep = func(time.Duration) {}
+		exchange = chaos
+	}
+
+	prices, err := parsePrices(*price)
+	if err != nil {
+		return err
+	}
+
+	var books []*Book
+	for _, m := range conf.Markets {
+		if m.Start == 0 || m.relative() {
+			p, ok := prices[m.Market]
+			if !ok {
+				return fmt.Errorf("%s: Start is automatic or High and Low are relative, set a starting price with -price %s=PRICE", m.Market, m.Market)
+			}
+			m.resolve(p)
+		}
+
+		var ts []Trader
+		for _, name := range strings.Split(*traders, ",") {
+			t, err := NewTrader(strings.TrimSpace(name), *volatility, 2*m.Quantity/m.Start)
+			if err != nil {
+				return err
+			}
+			ts = append(ts, t)
+		}
+
+		sim.AddMarket(m.Market, m.Start, ts...)
+		b, err := newBook(m, exchange, "")
+		if err != nil {
+			return err
+		}
+		books = append(books, b)
+	}
+
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	start := time.Now()
+	sim.Run(books, *duration, *step, *tick)
+
+	fmt.Printf("Simulated %v in %v, %d fills\n", *duration, time.Since(start), len(sim.Fills))
+	for _, b := range books {
+		ticker, _ := sim.GetTicker(b.Market)
+		asset, currency := splitMarket(b.Market)
+		fmt.Printf("%s: price %f, %s %f, %s %f\n", b.Market, ticker.Last, asset, sim.Balances[asset], currency, sim.Balances[currency])
+
+		// Orders resting on the exchange that the book has lost track of
+		known := map[string]bool{}
+		for _, o := range b.Orders {
+			known[o.UID] = true
+		}
+		open, _ := sim.GetOrders(b.Market)
+		orphans := 0
+		for _, uid := range open {
+			if !known[uid] {
+				orphans++
+			}
+		}
+		if orphans > 0 {
+			fmt.Printf("%s: %d orders on the exchange are not tracked by the book\n", b.Market, orphans)
+		}
+	}
+
+	return nil
+}
+
+// parsePrices parses a comma separated list of MARKET=PRICE pairs.
+func parsePrices(spec string) (map[string]float64, error) {
+	prices := map[string]float64{}
+	if spec == "" {
+		return prices, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Bad price %q, expected MARKET=PRICE", pair)
+		}
+		price, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("Bad price %q, expected MARKET=PRICE", pair)
+		}
+		prices[parts[0]] = price
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,65 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import "fmt"
+
+// Strategy decides which orders a Book keeps in the market. The levels of a
+// book are held in Book.Orders: a level with Filled set has no order resting
+// and is placed by the book at the end of the tick unless it is the Middle.
+type Strategy interface {
+	// Build returns the levels of the book from its parameters with the
+	// middle at the first level at or below the rate middle.
+	Build(b *Book, middle float64) ([]Order, error)
+
+	// OnFills is called every tick but the first with the indices of the
+	// levels whose orders filled since the last tick.
+	OnFills(b *Book, filled []int) error
+
+	// OnTicker is called every tick with the current ticker of the market.
+	OnTicker(b *Book, ticker Ticker) error
+
+	// Orders returns the levels the book should hold after this tick. Levels
+	// that keep the UID of a resting order with the same side, rate and
+	// quantity are left alone; resting orders that are dropped or changed are
+	// cancelled and changed levels are placed again.
+	Orders(b *Book) ([]Order, error)
+}
+
+// NewStrategy returns the strategy with the given name as used in the
+// Strategy field of a market in config.json.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case "", "grid":
+		return &Grid{}, nil
+	case "avellaneda":
+		return &Avellaneda{}, nil
+	case "pairs":
+		return &Pairs{}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown strategy: %s", name)
+}
+
+// strategyName returns the name of a strategy, books persisted before the
+// strategy could be chosen are grids.
+func strategyName(name string) string {
+	if name == "" {
+		return "grid"
+	}
+	return name
+}
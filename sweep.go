@@ -0,0 +1,238 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseRange parses a sweep parameter. It is either a comma separated list of
+// values or min:max:n for n evenly spaced values from min to max inclusive.
+// An empty spec yields def.
+func parseRange(spec string, def float64) ([]float64, error) {
+	if spec == "" {
+		return []float64{def}, nil
+	}
+
+	if parts := strings.Split(spec, ":"); len(parts) == 3 {
+		min, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		max, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("Range %s needs at least one value", spec)
+		}
+		if n == 1 {
+			return []float64{min}, nil
+		}
+
+		var ret []float64
+		for i := 0; i < n; i++ {
+			ret = append(ret, min+(max-min)*float64(i)/float64(n-1))
+		}
+		return ret, nil
+	}
+
+	var ret []float64
+	for _, v := range strings.Split(spec, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+// candidates returns the markets to test: every combination of the parameter
+// values or, if samples is positive, that many markets with each parameter
+// drawn uniformly between the least and greatest of its values.
+func candidates(base Market, high, low, start, interval, quantity []float64, samples int, rnd *rand.Rand) []Market {
+	var ret []Market
+
+	if samples > 0 {
+		draw := func(vals []float64) float64 {
+			min, max := vals[0], vals[0]
+			for _, v := range vals {
+				if v < min {
+					min = v
+				}
+				if v > max {
+					max = v
+				}
+			}
+			return min + (max-min)*rnd.Float64()
+		}
+
+		for i := 0; i < samples; i++ {
+			m := base
+			m.High, m.Low, m.Start = draw(high), draw(low), draw(start)
+			m.Interval, m.Quantity = draw(interval), draw(quantity)
+			ret = append(ret, m)
+		}
+		return ret
+	}
+
+	for _, h := range high {
+		for _, l := range low {
+			for _, s := range start {
+				for _, i := range interval {
+					for _, q := range quantity {
+						m := base
+						m.High, m.Low, m.Start, m.Interval, m.Quantity = h, l, s, i, q
+						ret = append(ret, m)
+					}
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// efficiency is the realized profit of a backtest as a fraction of the value
+// of the capital it required, valued at the start price.
+func efficiency(res BacktestResult) float64 {
+	capital := res.Currency + res.Asset*res.Market.Start
+	if capital <= 0 {
+		return 0
+	}
+	return res.Profit / capital
+}
+
+// sweep backtests a grid or random sample of parameters for a market in
+// parallel, ranks the results and writes the best as config.json market
+// entries.
+func sweep(args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	trades := fs.String("trades", "", "CSV file of historical trades")
+	market := fs.String("market", "", "market in config.json to base the sweep on")
+	high := fs.String("high", "", "High values, as a list a,b,c or a range min:max:n")
+	low := fs.String("low", "", "Low values")
+	start := fs.String("start", "", "Start values")
+	interval := fs.String("interval", "", "Interval values")
+	quantity := fs.String("quantity", "", "Quantity values")
+	samples := fs.Int("random", 0, "test this many random draws from the ranges instead of every combination")
+	seed := fs.Int64("seed", 1, "random seed")
+	rank := fs.String("rank", "profit", "rank by profit, drawdown or efficiency")
+	top := fs.Int("top", 5, "number of candidates to keep")
+	out := fs.String("out", "sweep.json", "file to write the best market entries to")
+	tick := fs.Duration("tick", 3*time.Second, "simulated time between book ticks")
+	fee := fs.Float64("fee", 0.0025, "maker fee")
+	workers := fs.Int("workers", runtime.NumCPU(), "backtests to run in parallel")
+	fs.Parse(args)
+
+	base, balances, err := configMarket(*market)
+	if err != nil {
+		return err
+	}
+
+	var ranges [5][]float64
+	for i, p := range []struct {
+		spec string
+		def  float64
+	}{{*high, base.High}, {*low, base.Low}, {*start, base.Start}, {*interval, base.Interval}, {*quantity, base.Quantity}} {
+		ranges[i], err = parseRange(p.spec, p.def)
+		if err != nil {
+			return err
+		}
+	}
+
+	var less func(a, b BacktestResult) bool
+	switch *rank {
+	case "profit":
+		less = func(a, b BacktestResult) bool { return a.Profit > b.Profit }
+	case "drawdown":
+		less = func(a, b BacktestResult) bool { return a.Drawdown < b.Drawdown }
+	case "efficiency":
+		less = func(a, b BacktestResult) bool { return efficiency(a) > efficiency(b) }
+	default:
+		return fmt.Errorf("Unknown ranking: %s", *rank)
+	}
+
+	t, err := LoadTrades(*trades)
+	if err != nil {
+		return err
+	}
+
+	markets := candidates(base, ranges[0], ranges[1], ranges[2], ranges[3], ranges[4], *samples, rand.New(rand.NewSource(*seed)))
+
+	log.Printf("Backtesting %d candidates over %d trades", len(markets), len(t))
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	jobs := make(chan Market)
+	var results []BacktestResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range jobs {
+				res, err := Backtest(m, t, *tick, *fee, balances)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%+v: %v\n", m, err)
+					continue
+				}
+
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, m := range markets {
+		jobs <- m
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool { return less(results[i], results[j]) })
+	if len(results) > *top {
+		results = results[:*top]
+	}
+
+	fmt.Printf("%12s %12s %12s %10s %10s %14s %10s %10s\n", "High", "Low", "Start", "Interval", "Quantity", "Profit", "Drawdown", "Efficiency")
+	var best []Market
+	for _, res := range results {
+		m := res.Market
+		fmt.Printf("%12.8f %12.8f %12.8f %10.6f %10.6f %14.8f %9.2f%% %9.2f%%\n", m.High, m.Low, m.Start, m.Interval, m.Quantity, res.Profit, res.Drawdown*100, efficiency(res)*100)
+		best = append(best, m)
+	}
+
+	return SaveStruct(*out, best)
+}
@@ -0,0 +1,140 @@
+/* mmbot - a constant interval market maker bot
+Copyright (C) 2018  James Lovejoy
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// The synthetic traders below each follow their own model of fair value and
+// quote liquidity around it, trading the market towards it as it moves.
+// Volatility is the standard deviation of the log price per hour and Size is
+// the typical quantity of asset a trader quotes on each level.
+
+// RandomWalk moves fair value as a driftless geometric random walk.
+type RandomWalk struct {
+	Volatility float64
+	Size       float64
+	fair       float64
+}
+
+func (t *RandomWalk) Trade(m *SimMarket, rnd *rand.Rand, dt time.Duration) {
+	if t.fair == 0 {
+		t.fair = m.Ticker().Last
+	}
+
+	t.fair *= math.Exp(t.Volatility * math.Sqrt(dt.Hours()) * rnd.NormFloat64())
+	m.Quote(t.fair, t.Size, rnd)
+}
+
+// Trending is a random walk with a constant drift, as a fraction of the price
+// per hour.
+type Trending struct {
+	Drift      float64
+	Volatility float64
+	Size       float64
+	fair       float64
+}
+
+func (t *Trending) Trade(m *SimMarket, rnd *rand.Rand, dt time.Duration) {
+	if t.fair == 0 {
+		t.fair = m.Ticker().Last
+	}
+
+	t.fair *= math.Exp(t.Drift*dt.Hours() + t.Volatility*math.Sqrt(dt.Hours())*rnd.NormFloat64())
+	m.Quote(t.fair, t.Size, rnd)
+}
+
+// MeanReverting pulls fair value back towards Mean, closing the fraction Speed
+// of the gap every hour. A zero Mean reverts to the price the market opened at.
+type MeanReverting struct {
+	Mean       float64
+	Speed      float64
+	Volatility float64
+	Size       float64
+	fair       float64
+}
+
+func (t *MeanReverting) Trade(m *SimMarket, rnd *rand.Rand, dt time.Duration) {
+	if t.fair == 0 {
+		t.fair = m.Ticker().Last
+		if t.Mean == 0 {
+			t.Mean = t.fair
+		}
+	}
+
+	pull := t.Speed * dt.Hours() * math.Log(t.Mean/t.fair)
+	t.fair *= math.Exp(pull + t.Volatility*math.Sqrt(dt.Hours())*rnd.NormFloat64())
+	m.Quote(t.fair, t.Size, rnd)
+}
+
+// FlashCrash is a random walk that on average once every Every drops by the
+// fraction Depth in a single step, dumping Size times Panic into the bids on the
+// way down, and then recovers to its pre-crash value over Recovery.
+type FlashCrash struct {
+	Every      time.Duration
+	Depth      float64
+	Recovery   time.Duration
+	Panic      float64
+	Volatility float64
+	Size       float64
+	fair       float64
+	target     float64
+	left       time.Duration
+}
+
+func (t *FlashCrash) Trade(m *SimMarket, rnd *rand.Rand, dt time.Duration) {
+	if t.fair == 0 {
+		t.fair = m.Ticker().Last
+	}
+
+	t.fair *= math.Exp(t.Volatility * math.Sqrt(dt.Hours()) * rnd.NormFloat64())
+
+	if t.left > 0 {
+		t.fair += (t.target - t.fair) * math.Min(1, float64(dt)/float64(t.left))
+		t.left -= dt
+	} else if t.Every > 0 && rnd.Float64() < float64(dt)/float64(t.Every) {
+		t.target = t.fair
+		t.left = t.Recovery
+		t.fair *= 1 - t.Depth
+
+		m.Cancel()
+		m.Submit(false, t.Size*t.Panic, t.fair, false)
+	}
+
+	m.Quote(t.fair, t.Size, rnd)
+}
+
+// NewTrader returns a synthetic trader by name with default parameters scaled
+// by volatility and size.
+func NewTrader(name string, volatility float64, size float64) (Trader, error) {
+	switch name {
+	case "randomwalk":
+		return &RandomWalk{Volatility: volatility, Size: size}, nil
+	case "trending":
+		return &Trending{Drift: volatility / 4, Volatility: volatility, Size: size}, nil
+	case "meanreverting":
+		return &MeanReverting{Speed: 0.5, Volatility: volatility, Size: size}, nil
+	case "flashcrash":
+		return &FlashCrash{Every: 12 * time.Hour, Depth: 0.3, Recovery: time.Hour, Panic: 50, Volatility: volatility, Size: size}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown trader: %s", name)
+}